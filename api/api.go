@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
@@ -8,20 +9,47 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/PFrek/chirpy/blob"
 	"github.com/PFrek/chirpy/db"
+	"github.com/PFrek/chirpy/keyring"
+	"github.com/PFrek/chirpy/mailer"
+	"github.com/PFrek/chirpy/passhash"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type ApiConfig struct {
 	fileserverHits int
 	DB             *db.DB
-	JWTSecret      string
+	Blobs          *blob.Store
+	Keys           *keyring.KeyRing
+	JWT            JWTConfig
 	PolkaKey       string
+	// Maximum chirp length per user tier, as counted by text.ChirpLength
+	ChirpLengthLimits ChirpLengthLimits
+	Spam              SpamConfig
+	// Active sessions allowed per user before the oldest is evicted, 0 for
+	// no limit
+	MaxSessions    int
+	LoginThrottle  *LoginThrottle
+	Passwords      passhash.Config
+	PasswordPolicy passhash.Policy
+	Mailer         mailer.Mailer
+	// Public address of the server, used for links in emails
+	BaseURL      string
+	Verification VerificationConfig
+	// Spaces out the verification emails a user can ask for
+	VerificationResends *RateLimiter
+	// Limit password reset emails per address and per IP, so the endpoint
+	// can't be used to flood an inbox
+	ResetRequestsByAddress *RateLimiter
+	ResetRequestsByIP      *RateLimiter
+	// Compared against when logging in with an unknown email, so it takes
+	// as long as a wrong password. Set by HashDummyPassword.
+	dummyPasswordHash string
 }
 
 func (config *ApiConfig) MiddlewareMetricsInc(next http.Handler) http.Handler {
@@ -32,6 +60,12 @@ func (config *ApiConfig) MiddlewareMetricsInc(next http.Handler) http.Handler {
 	})
 }
 
+// Serves the files in root, which must only hold the web pages and their
+// assets, under /app/
+func (config *ApiConfig) AppHandler(root string) http.Handler {
+	return config.MiddlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir(root))))
+}
+
 func (config *ApiConfig) MetricsHandler(writer http.ResponseWriter, req *http.Request) {
 	writer.Header().Set("Content-Type", "text/html")
 	writer.WriteHeader(200)
@@ -46,6 +80,7 @@ func (config *ApiConfig) MetricsHandler(writer http.ResponseWriter, req *http.Re
 
 func (config *ApiConfig) ResetHandler(writer http.ResponseWriter, req *http.Request) {
 	config.fileserverHits = 0
+	config.audit(req, requestActor(req).Id, AuditMetricsReset, "", 0, "")
 	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	writer.WriteHeader(200)
 	writer.Write([]byte("Fileserver hits counter reset to 0"))
@@ -95,59 +130,110 @@ func RespondWithJSON(writer http.ResponseWriter, code int, payload interface{})
 
 }
 
-func replaceProfaneWords(chirp string) string {
-	badWords := []string{
-		"kerfuffle",
-		"sharbert",
-		"fornax",
+func newRefreshTokenString() (string, error) {
+	data := make([]byte, 32)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
 	}
 
-	replacedWords := []string{}
-	for _, word := range strings.Split(chirp, " ") {
-		if slices.Contains(badWords, strings.ToLower(word)) {
-			replacedWords = append(replacedWords, strings.Repeat("*", 4))
-			continue
-		}
+	return hex.EncodeToString(data), nil
+}
 
-		replacedWords = append(replacedWords, word)
+func clientInfo(req *http.Request) db.ClientInfo {
+	return db.ClientInfo{
+		IP:        clientIP(req),
+		UserAgent: req.UserAgent(),
 	}
-
-	return strings.Join(replacedWords, " ")
 }
 
-func (config *ApiConfig) generateRefreshToken(id int) (string, error) {
-	data := make([]byte, 32)
-	_, err := rand.Read(data)
+// Starts a new session for the user, returning its refresh token and id
+func (config *ApiConfig) generateRefreshToken(id int, req *http.Request) (string, string, error) {
+	hex, err := newRefreshTokenString()
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	hex := hex.EncodeToString(data)
-
-	err = config.DB.CreateRefreshToken(hex, id)
+	sessionId, err := config.DB.CreateRefreshToken(hex, id, clientInfo(req), config.MaxSessions)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	return hex, nil
+	return hex, sessionId, nil
 }
 
-func (config *ApiConfig) generateJWTToken(id int) (string, error) {
-	expiration := time.Duration(1) * time.Hour
+const jwtIssuer = "chirpy"
+
+type JWTConfig struct {
+	// Value of the aud claim tokens are issued for and required to carry
+	Audience string
+	// Allowed clock skew when checking exp, nbf and iat
+	Leeway time.Duration
+}
+
+// Codes returned alongside 401 responses so clients can tell whether
+// refreshing the token will help
+const (
+	AuthErrorMissingToken  = "missing_token"
+	AuthErrorMalformed     = "token_malformed"
+	AuthErrorExpired       = "token_expired"
+	AuthErrorBadSignature  = "bad_signature"
+	AuthErrorInvalidClaims = "invalid_claims"
+	AuthErrorInvalidToken  = "invalid_token"
+	AuthErrorRevoked       = "token_revoked"
+)
+
+type AuthError struct {
+	Code    string
+	Message string
+}
 
-	claims := &jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiration)),
-		Subject:   fmt.Sprint(id),
+func (err AuthError) Error() string {
+	return err.Message
+}
+
+func newTokenError(err error) AuthError {
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return AuthError{Code: AuthErrorMalformed, Message: "Token is malformed"}
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return AuthError{Code: AuthErrorExpired, Message: "Token is expired"}
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return AuthError{Code: AuthErrorBadSignature, Message: "Token signature is invalid"}
+	case errors.Is(err, jwt.ErrTokenInvalidClaims):
+		return AuthError{Code: AuthErrorInvalidClaims, Message: err.Error()}
 	}
 
-	token := jwt.NewWithClaims(
-		jwt.SigningMethodHS256,
-		claims,
-	)
+	return AuthError{Code: AuthErrorInvalidToken, Message: "Token is invalid"}
+}
+
+type ChirpyClaims struct {
+	// Role of the user when the token was issued
+	Role string `json:"role,omitempty"`
+	// Must match the user's token version, which logging out everywhere bumps
+	TokenVersion int `json:"ver"`
+	// Session the token was issued for
+	SessionId string `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
 
-	tokenStr, err := token.SignedString([]byte(config.JWTSecret))
+func (config *ApiConfig) generateJWTToken(user db.User, sessionId string) (string, error) {
+	expiration := time.Duration(1) * time.Hour
+
+	claims := &ChirpyClaims{
+		Role:         user.Role,
+		TokenVersion: user.TokenVersion,
+		SessionId:    sessionId,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    jwtIssuer,
+			Audience:  jwt.ClaimStrings{config.JWT.Audience},
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiration)),
+			Subject:   fmt.Sprint(user.Id),
+		},
+	}
+
+	tokenStr, err := config.Keys.Sign(claims)
 	return tokenStr, err
 
 }
@@ -164,29 +250,145 @@ func ExtractAuthorization(req *http.Request) (string, error) {
 }
 
 func (config *ApiConfig) AuthenticateRequest(req *http.Request) (int, error) {
+	user, _, err := config.authenticate(req)
+	if err != nil {
+		return 0, err
+	}
+
+	return user.Id, nil
+}
+
+// Validates the request's JWT, returning the user it belongs to along with
+// the token's claims
+func (config *ApiConfig) authenticate(req *http.Request) (db.User, *ChirpyClaims, error) {
 	tokenStr, err := ExtractAuthorization(req)
 	if err != nil {
-		return 0, errors.New("Unauthorized")
+		return db.User{}, nil, AuthError{Code: AuthErrorMissingToken, Message: "Missing bearer token"}
 	}
 
-	token, err := jwt.ParseWithClaims(tokenStr, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.JWTSecret), nil
-	})
+	claims := &ChirpyClaims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, config.Keys.Keyfunc,
+		jwt.WithValidMethods([]string{keyring.AlgorithmEdDSA, keyring.AlgorithmRS256}),
+		jwt.WithIssuer(jwtIssuer),
+		jwt.WithAudience(config.JWT.Audience),
+		jwt.WithLeeway(config.JWT.Leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
 	if err != nil {
-		return 0, errors.New("Unauthorized")
+		return db.User{}, nil, newTokenError(err)
 	}
 
 	idStr, err := token.Claims.GetSubject()
 	if err != nil {
-		return 0, errors.New("Unauthorized")
+		return db.User{}, nil, AuthError{Code: AuthErrorInvalidClaims, Message: "Token has no subject"}
 	}
 
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		return 0, errors.New("Unauthorized")
+		return db.User{}, nil, AuthError{Code: AuthErrorInvalidClaims, Message: "Token has an invalid subject"}
+	}
+
+	user, err := config.DB.GetUserById(id)
+	if err != nil {
+		return db.User{}, nil, AuthError{Code: AuthErrorInvalidToken, Message: "Token user not found"}
+	}
+
+	if claims.TokenVersion != user.TokenVersion {
+		return db.User{}, nil, AuthError{Code: AuthErrorRevoked, Message: "Token has been revoked"}
+	}
+
+	// Tokens issued before sessions were tracked have no session to check
+	if len(claims.SessionId) > 0 {
+		active, err := config.DB.IsSessionActive(user.Id, claims.SessionId)
+		if err != nil {
+			return db.User{}, nil, err
+		}
+
+		if !active {
+			return db.User{}, nil, AuthError{Code: AuthErrorRevoked, Message: "Session has been revoked"}
+		}
+	}
+
+	if user.IsSuspended() {
+		return db.User{}, nil, SuspendedError{ExpiresAt: user.Suspension.ExpiresAt}
+	}
+
+	return user, claims, nil
+}
+
+type actorContextKey struct{}
+
+// Returns the user let through by MiddlewareRequireRole
+func requestActor(req *http.Request) db.User {
+	actor, _ := req.Context().Value(actorContextKey{}).(db.User)
+	return actor
+}
+
+// Only lets requests through if they carry a token whose role claim is at
+// least the given role. The role is also checked against the db so
+// demotions take effect before old tokens expire. Handlers get the user
+// from requestActor.
+func (config *ApiConfig) MiddlewareRequireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		user, claims, err := config.authenticate(req)
+		if err != nil {
+			RespondWithAuthError(writer, err)
+			return
+		}
+
+		if db.RoleRank(claims.Role) < db.RoleRank(role) || !user.HasRole(role) {
+			RespondWithError(writer, 403, "Forbidden")
+			return
+		}
+
+		next.ServeHTTP(writer, req.WithContext(context.WithValue(req.Context(), actorContextKey{}, user)))
+	})
+}
+
+type SuspendedError struct {
+	ExpiresAt *time.Time
+}
+
+func (err SuspendedError) Error() string {
+	if err.ExpiresAt == nil {
+		return "Account suspended"
+	}
+
+	return fmt.Sprintf("Account suspended until %s", err.ExpiresAt.Format(time.RFC3339))
+}
+
+// Responds to a failed AuthenticateRequest: suspended accounts are
+// authenticated but forbidden, anything else is unauthorized
+func RespondWithAuthError(writer http.ResponseWriter, err error) {
+	type errBody struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+
+	var suspendedErr SuspendedError
+	if errors.As(err, &suspendedErr) {
+		RespondWithJSON(writer, 403, errBody{Error: err.Error(), Code: "account_suspended"})
+		return
+	}
+
+	code := AuthErrorInvalidToken
+	var authErr AuthError
+	if errors.As(err, &authErr) {
+		code = authErr.Code
+	}
+
+	RespondWithJSON(writer, 401, errBody{Error: err.Error(), Code: code})
+}
+
+// Returns the id of the authenticated user, or 0 for anonymous requests
+func (config *ApiConfig) AuthenticateOptional(req *http.Request) int {
+	id, err := config.AuthenticateRequest(req)
+	if err != nil {
+		return 0
 	}
 
-	return id, nil
+	return id
 }
 
 func (config *ApiConfig) AuthenticatePolkaKey(req *http.Request) error {
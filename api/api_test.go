@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAppHandlerOnlyServesPages(t *testing.T) {
+	// Laid out like a deployment, with the pages next to the server's data
+	dir := t.TempDir()
+	files := map[string]string{
+		"static/index.html":    "<h1>Chirpy</h1>",
+		"database.json":        "{}",
+		"keys/0123456789.pem":  "PRIVATE KEY",
+		"audit.key":            "secret",
+		".env":                 "POLKA_KEY=secret",
+		"static/assets/a.png":  "png",
+		"blobs/0123456789abcd": "blob",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		root   string
+		target string
+		want   int
+	}{
+		{"page", filepath.Join(dir, "static"), "/app/", 200},
+		{"asset", filepath.Join(dir, "static"), "/app/assets/a.png", 200},
+		{"database", filepath.Join(dir, "static"), "/app/database.json", 404},
+		{"key", filepath.Join(dir, "static"), "/app/keys/0123456789.pem", 404},
+		{"audit key", filepath.Join(dir, "static"), "/app/audit.key", 404},
+		{"env", filepath.Join(dir, "static"), "/app/.env", 404},
+		{"parent directory", filepath.Join(dir, "static"), "/app/../database.json", 404},
+		// The pages shipped with the repo
+		{"verification page", "../static", "/app/verify-email.html", 200},
+		{"reset page", "../static", "/app/reset-password.html", 200},
+		{"source", "../static", "/app/go.mod", 404},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &ApiConfig{}
+			recorder := httptest.NewRecorder()
+			config.AppHandler(test.root).ServeHTTP(recorder, httptest.NewRequest("GET", test.target, nil))
+			if recorder.Code != test.want {
+				t.Errorf("status = %d, want %d", recorder.Code, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/PFrek/chirpy/db"
+	"github.com/PFrek/chirpy/media"
+)
+
+const (
+	maxAttachmentSize    = 5 << 20
+	maxRedAttachmentSize = 15 << 20
+	maxChirpAttachments  = 4
+)
+
+func attachmentURL(hash string) string {
+	return "/media/" + hash
+}
+
+func (config *ApiConfig) PostAttachmentsHandler(writer http.ResponseWriter, req *http.Request) {
+	id, err := config.AuthenticateRequest(req)
+	if err != nil {
+		RespondWithAuthError(writer, err)
+		return
+	}
+
+	user, err := config.DB.GetUserById(id)
+	if err != nil {
+		RespondWithError(writer, 401, "Unauthorized")
+		return
+	}
+
+	if !config.requireVerifiedEmail(writer, user, VerifiedActionAttachment) {
+		return
+	}
+
+	maxSize := int64(maxAttachmentSize)
+	if user.IsChirpyRed {
+		maxSize = maxRedAttachmentSize
+	}
+
+	// Leave some headroom for the multipart boundaries and headers
+	req.Body = http.MaxBytesReader(writer, req.Body, maxSize+(1<<20))
+
+	file, _, err := req.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			RespondWithError(writer, 413, fmt.Sprintf("File exceeds the %d byte limit", maxSize))
+			return
+		}
+
+		RespondWithError(writer, 400, "Missing [file] in multipart form")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	if int64(len(data)) > maxSize {
+		RespondWithError(writer, 413, fmt.Sprintf("File exceeds the %d byte limit", maxSize))
+		return
+	}
+
+	contentType, err := media.DetectType(data)
+	if err != nil {
+		RespondWithError(writer, 415, err.Error())
+		return
+	}
+
+	data, err = media.StripMetadata(contentType, data)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	width, height, err := media.Dimensions(data)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	generated, err := media.GenerateVariants(contentType, data)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	hash, err := config.Blobs.Put(data)
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	variants := []db.AttachmentVariant{}
+	for _, variant := range generated {
+		// The original already fits within this variant's bounds
+		variantHash := hash
+		if variant.Data != nil {
+			variantHash, err = config.Blobs.Put(variant.Data)
+			if err != nil {
+				RespondWithError(writer, 500, err.Error())
+				return
+			}
+		}
+
+		variants = append(variants, db.AttachmentVariant{
+			Name:        variant.Name,
+			Hash:        variantHash,
+			ContentType: variant.ContentType,
+			URL:         attachmentURL(variantHash),
+			Width:       variant.Width,
+			Height:      variant.Height,
+		})
+	}
+
+	attachment, err := config.DB.CreateAttachment(db.Attachment{
+		OwnerId:     id,
+		Hash:        hash,
+		ContentType: contentType,
+		Size:        len(data),
+		URL:         attachmentURL(hash),
+		Width:       width,
+		Height:      height,
+		Variants:    variants,
+	})
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	RespondWithJSON(writer, 201, attachment)
+}
+
+func (config *ApiConfig) GetMediaHandler(writer http.ResponseWriter, req *http.Request) {
+	hash := req.PathValue("hash")
+	if !config.Blobs.Exists(hash) {
+		RespondWithError(writer, 404, "Not Found")
+		return
+	}
+
+	access, err := config.DB.GetBlobAccess(hash, config.AuthenticateOptional(req))
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	// Same as chirps, media the viewer can't see is reported as missing
+	if !access.Visible {
+		RespondWithError(writer, 404, "Not Found")
+		return
+	}
+
+	// Blobs are content-addressed, so they never change. Who may see them
+	// can, so only public ones are cached by shared caches.
+	if access.Public {
+		writer.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		writer.Header().Set("Cache-Control", "private, no-cache")
+		writer.Header().Set("Vary", "Authorization")
+	}
+	writer.Header().Set("Content-Type", access.ContentType)
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeFile(writer, req, config.Blobs.Path(hash))
+}
+
+// Resolves attachment ids sent with a new chirp, ensuring they belong to
+// the author
+func (config *ApiConfig) resolveAttachments(ids []int, ownerId int) ([]db.Attachment, error) {
+	if len(ids) > maxChirpAttachments {
+		return nil, fmt.Errorf("A chirp can have at most %d attachments", maxChirpAttachments)
+	}
+
+	attachments := []db.Attachment{}
+	for i, id := range ids {
+		if slices.Contains(ids[:i], id) {
+			return nil, fmt.Errorf("Duplicate attachment id: %d", id)
+		}
+
+		attachment, err := config.DB.GetAttachmentById(id)
+		if err != nil || attachment.OwnerId != ownerId {
+			return nil, fmt.Errorf("Invalid attachment id: %d", id)
+		}
+
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, nil
+}
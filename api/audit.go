@@ -0,0 +1,118 @@
+package api
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PFrek/chirpy/db"
+)
+
+const (
+	AuditLogin            = "login"
+	AuditLoginFailed      = "login_failed"
+	AuditTokenRefresh     = "token_refresh"
+	AuditTokenRevoke      = "token_revoke"
+	AuditTokenReuse       = "token_reuse"
+	AuditSessionRevoke    = "session_revoke"
+	AuditLogoutAll        = "logout_all"
+	AuditTwoFactorEnable  = "two_factor_enable"
+	AuditTwoFactorDisable = "two_factor_disable"
+	AuditUserUpdate       = "user_update"
+	AuditEmailVerify      = "email_verify"
+	AuditPasswordReset    = "password_reset"
+	AuditPasswordChange   = "password_change"
+	AuditUserUpgrade      = "user_upgrade"
+	AuditChirpDelete      = "chirp_delete"
+	AuditRoleChange       = "role_change"
+	AuditSuspend          = "suspend"
+	AuditUnsuspend        = "unsuspend"
+	AuditShadowban        = "shadowban"
+	AuditUnshadowban      = "unshadowban"
+	AuditReportResolve    = "report_resolve"
+	AuditProfanityRuleSet = "profanity_rule_set"
+	AuditProfanityRuleDel = "profanity_rule_delete"
+	AuditMetricsReset     = "metrics_reset"
+	AuditKeyRotate        = "key_rotate"
+	AuditKeyRetire        = "key_retire"
+)
+
+const (
+	AuditTargetUser          = "user"
+	AuditTargetChirp         = "chirp"
+	AuditTargetReport        = "report"
+	AuditTargetProfanityRule = "profanity_rule"
+)
+
+// Returns the address of the client that sent the request
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
+// Records an action in the audit log. Failing to write the entry is logged
+// but doesn't fail the request, since the action has already happened.
+func (config *ApiConfig) audit(req *http.Request, actorId int, action string, targetType string, targetId int, details string) {
+	_, err := config.DB.AppendAuditEntry(db.AuditEntry{
+		ActorId:    actorId,
+		Action:     action,
+		TargetType: targetType,
+		TargetId:   targetId,
+		IP:         clientIP(req),
+		UserAgent:  req.UserAgent(),
+		Details:    details,
+	})
+	if err != nil {
+		log.Printf("Failed to write audit entry for %s: %v", action, err)
+	}
+}
+
+func parseTime(query string) (time.Time, error) {
+	return time.Parse(time.RFC3339, query)
+}
+
+func createAuditFilters(req *http.Request) db.AuditFilter {
+	return db.AuditFilter{
+		ActorId:    extractQuery("actor_id", req, strconv.Atoi),
+		Action:     extractQuery("action", req, nonEmptyString),
+		TargetType: extractQuery("target_type", req, nonEmptyString),
+		TargetId:   extractQuery("target_id", req, strconv.Atoi),
+		Since:      extractQuery("since", req, parseTime),
+		Until:      extractQuery("until", req, parseTime),
+	}
+}
+
+func (config *ApiConfig) GetAuditHandler(writer http.ResponseWriter, req *http.Request) {
+	entries, err := config.DB.GetAuditEntries(createAuditFilters(req))
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	RespondWithJSON(writer, 200, entries)
+}
+
+func (config *ApiConfig) GetAuditVerifyHandler(writer http.ResponseWriter, req *http.Request) {
+	brokenAt, err := config.DB.VerifyAuditLog()
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	response := struct {
+		Valid bool `json:"valid"`
+		// Id of the first entry that doesn't match the chain, if any
+		BrokenAt int `json:"broken_at,omitempty"`
+	}{
+		Valid:    brokenAt == 0,
+		BrokenAt: brokenAt,
+	}
+
+	RespondWithJSON(writer, 200, response)
+}
@@ -5,16 +5,46 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/PFrek/chirpy/db"
+	"github.com/PFrek/chirpy/text"
 )
 
+const (
+	maxContentWarningLength    = 100
+	DefaultChirpLengthLimit    = 140
+	DefaultRedChirpLengthLimit = 280
+)
+
+type ChirpLengthLimits struct {
+	Default   int
+	ChirpyRed int
+}
+
+func (config *ApiConfig) chirpLengthLimit(user db.User) int {
+	if user.IsChirpyRed {
+		return config.ChirpLengthLimits.ChirpyRed
+	}
+
+	return config.ChirpLengthLimits.Default
+}
+
 type extractor[T comparable] func(string) (T, error)
 
 func noOpString(query string) (string, error) {
 	return query, nil
 }
 
+func nonEmptyString(query string) (string, error) {
+	if len(query) == 0 {
+		return "", errors.New("Empty query")
+	}
+
+	return query, nil
+}
+
 func extractQuery[T comparable](name string, req *http.Request, ex extractor[T]) *T {
 	var filter *T
 	val, err := ex(req.URL.Query().Get(name))
@@ -26,10 +56,12 @@ func extractQuery[T comparable](name string, req *http.Request, ex extractor[T])
 	return filter
 }
 
-func createChirpFilters(req *http.Request) db.ChirpFilter {
+func createChirpFilters(req *http.Request, viewerId int) db.ChirpFilter {
 	return db.ChirpFilter{
-		AuthorId: extractQuery("author_id", req, strconv.Atoi),
-		Contains: extractQuery("contains", req, noOpString),
+		AuthorId:      extractQuery("author_id", req, strconv.Atoi),
+		Contains:      extractQuery("contains", req, noOpString),
+		HideSensitive: extractQuery("hide_sensitive", req, strconv.ParseBool),
+		ViewerId:      viewerId,
 	}
 }
 
@@ -39,15 +71,27 @@ func createChirpSorter(req *http.Request) db.ChirpSorter {
 	}
 }
 
+// Strips moderation state from chirps returned by public endpoints, so
+// authors can't tell their chirp was flagged or held
+func hideModerationState(chirp db.Chirp) db.Chirp {
+	chirp.Flagged = false
+	chirp.Held = false
+	return chirp
+}
+
 func (config *ApiConfig) PostChirpsHandler(writer http.ResponseWriter, req *http.Request) {
 	id, err := config.AuthenticateRequest(req)
 	if err != nil {
-		RespondWithError(writer, 401, err.Error())
+		RespondWithAuthError(writer, err)
 		return
 	}
 
 	type parameters struct {
-		Body string `json:"body"`
+		Body           string `json:"body"`
+		Visibility     string `json:"visibility"`
+		ContentWarning string `json:"content_warning"`
+		Sensitive      bool   `json:"sensitive"`
+		AttachmentIds  []int  `json:"attachment_ids"`
 	}
 
 	params := parameters{}
@@ -57,24 +101,122 @@ func (config *ApiConfig) PostChirpsHandler(writer http.ResponseWriter, req *http
 		return
 	}
 
-	if len(params.Body) > 140 {
-		RespondWithError(writer, 400, "Chirp is too long")
+	author, err := config.DB.GetUserById(id)
+	if err != nil {
+		RespondWithError(writer, 401, "Unauthorized")
 		return
 	}
 
-	cleanedBody := replaceProfaneWords(params.Body)
+	if !config.requireVerifiedEmail(writer, author, VerifiedActionChirp) {
+		return
+	}
+
+	limit := config.chirpLengthLimit(author)
+	length := text.ChirpLength(params.Body)
+	if length > limit {
+		RespondWithJSON(writer, 400, struct {
+			Error  string `json:"error"`
+			Length int    `json:"length"`
+			Limit  int    `json:"limit"`
+		}{
+			Error:  "Chirp is too long",
+			Length: length,
+			Limit:  limit,
+		})
+		return
+	}
+
+	params.ContentWarning = strings.TrimSpace(params.ContentWarning)
+	if text.GraphemeCount(params.ContentWarning) > maxContentWarningLength {
+		RespondWithError(writer, 400, "Content warning is too long")
+		return
+	}
+
+	if len(params.Visibility) == 0 {
+		params.Visibility = db.VisibilityPublic
+	}
+
+	if !db.IsValidVisibility(params.Visibility) {
+		RespondWithError(writer, 400, "Invalid visibility")
+		return
+	}
+
+	attachments, err := config.resolveAttachments(params.AttachmentIds, id)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	filteredBody, err := config.filterProfanity(params.Body)
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
 
-	chirp, err := config.DB.CreateChirp(cleanedBody, id)
+	filteredWarning, err := config.filterProfanity(params.ContentWarning)
 	if err != nil {
 		RespondWithError(writer, 500, err.Error())
 		return
 	}
 
-	RespondWithJSON(writer, 201, chirp)
+	if filteredBody.Rejected || filteredWarning.Rejected {
+		RespondWithError(writer, 400, "Chirp contains prohibited language")
+		return
+	}
+
+	// Scored against the stored form of the body, and checked and created
+	// under one lock so parallel posts can't get around the rate limits
+	var spam SpamVerdict
+	chirp, err := config.DB.CreateChirpChecked(db.Chirp{
+		Body:           filteredBody.Text,
+		AuthorId:       id,
+		Visibility:     params.Visibility,
+		ContentWarning: filteredWarning.Text,
+		Sensitive:      params.Sensitive,
+		Attachments:    attachments,
+		Flagged:        filteredBody.Flagged || filteredWarning.Flagged,
+	}, time.Now().UTC().Add(-config.spamLookback()), func(recent []db.Chirp, chirp *db.Chirp) error {
+		spam = config.scoreChirp(author, chirp.Body, recent)
+		if spam.Verdict == SpamVerdictThrottle || spam.Verdict == SpamVerdictReject {
+			return SpamRejectedError{Verdict: spam}
+		}
+
+		chirp.Held = spam.Verdict == SpamVerdictHold
+		return nil
+	})
+	if err != nil {
+		spamErr := SpamRejectedError{}
+		if !errors.As(err, &spamErr) {
+			RespondWithError(writer, 500, err.Error())
+			return
+		}
+
+		if spamErr.Verdict.Verdict == SpamVerdictThrottle {
+			writer.Header().Set("Retry-After", fmt.Sprint(int(spamErr.Verdict.RetryAfter.Seconds())+1))
+			RespondWithError(writer, 429, spamErr.Verdict.Reasons[0])
+			return
+		}
+
+		RespondWithError(writer, 422, spamErr.Error())
+		return
+	}
+
+	if chirp.Flagged {
+		matches := append(filteredBody.Matches, filteredWarning.Matches...)
+		config.fileAutomatedReport(db.ReportTargetChirp, chirp.Id, fmt.Sprintf("Matched profanity rules: %s", strings.Join(matches, ", ")))
+	}
+
+	// Held chirps are returned as usual so spammers can't tell they were caught
+	if chirp.Held {
+		config.fileAutomatedReport(db.ReportTargetChirp, chirp.Id, fmt.Sprintf("Spam score %d: %s", spam.Score, strings.Join(spam.Reasons, ", ")))
+	}
+
+	RespondWithJSON(writer, 201, hideModerationState(chirp))
 }
 
 func (config *ApiConfig) GetChirpsHandler(writer http.ResponseWriter, req *http.Request) {
-	chirpFilters := createChirpFilters(req)
+	viewerId := config.AuthenticateOptional(req)
+	chirpFilters := createChirpFilters(req, viewerId)
 	chirpSorter := createChirpSorter(req)
 
 	chirps, err := config.DB.GetChirps(chirpFilters, chirpSorter)
@@ -83,6 +225,10 @@ func (config *ApiConfig) GetChirpsHandler(writer http.ResponseWriter, req *http.
 		return
 	}
 
+	for i := range chirps {
+		chirps[i] = hideModerationState(chirps[i])
+	}
+
 	RespondWithJSON(writer, 200, chirps)
 }
 
@@ -104,13 +250,26 @@ func (config *ApiConfig) GetChirpHandler(writer http.ResponseWriter, req *http.R
 		return
 	}
 
-	RespondWithJSON(writer, 200, chirp)
+	viewerId := config.AuthenticateOptional(req)
+	visible, err := config.DB.CanViewChirp(chirp, viewerId)
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	// Hidden chirps are reported as missing so their existence isn't leaked
+	if !visible {
+		RespondWithError(writer, 404, "Not Found")
+		return
+	}
+
+	RespondWithJSON(writer, 200, hideModerationState(chirp))
 }
 
 func (config *ApiConfig) DeleteChirpHandler(writer http.ResponseWriter, req *http.Request) {
 	userId, err := config.AuthenticateRequest(req)
 	if err != nil {
-		RespondWithError(writer, 401, err.Error())
+		RespondWithAuthError(writer, err)
 		return
 	}
 
@@ -131,6 +290,18 @@ func (config *ApiConfig) DeleteChirpHandler(writer http.ResponseWriter, req *htt
 		return
 	}
 
+	visible, err := config.DB.CanViewChirp(chirp, userId)
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	// Same as reads, chirps the user can't see are reported as missing
+	if !visible {
+		RespondWithError(writer, 404, "Not Found")
+		return
+	}
+
 	if chirp.AuthorId != userId {
 		RespondWithError(writer, 403, "Forbidden")
 		return
@@ -142,5 +313,7 @@ func (config *ApiConfig) DeleteChirpHandler(writer http.ResponseWriter, req *htt
 		return
 	}
 
+	config.audit(req, userId, AuditChirpDelete, AuditTargetChirp, chirpId, "")
+
 	writer.WriteHeader(204)
 }
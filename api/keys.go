@@ -0,0 +1,58 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/PFrek/chirpy/keyring"
+)
+
+type ResponseKey struct {
+	Id          string `json:"kid"`
+	Algorithm   string `json:"alg"`
+	CreatedAt   string `json:"created_at"`
+	ActivatesAt string `json:"activates_at"`
+	Signing     bool   `json:"signing"`
+}
+
+// Serves the public keys tokens are signed with, so other services can
+// verify Chirpy tokens without sharing a secret
+func (config *ApiConfig) JWKSHandler(writer http.ResponseWriter, req *http.Request) {
+	writer.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(keyring.JWKSMaxAge.Seconds())))
+	RespondWithJSON(writer, 200, config.Keys.JWKS())
+}
+
+// Publishes a new key, which only starts signing once verifiers have had time
+// to refresh their cached key sets
+func (config *ApiConfig) PostRotateKeysHandler(writer http.ResponseWriter, req *http.Request) {
+	key, err := config.Keys.Rotate()
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	config.audit(req, requestActor(req).Id, AuditKeyRotate, "", 0, key.Id)
+
+	RespondWithJSON(writer, 201, ResponseKey{
+		Id:          key.Id,
+		Algorithm:   key.Algorithm,
+		CreatedAt:   key.CreatedAt.UTC().Format(time.RFC3339),
+		ActivatesAt: key.ActivatesAt.UTC().Format(time.RFC3339),
+		Signing:     config.Keys.SigningKey().Id == key.Id,
+	})
+}
+
+func (config *ApiConfig) DeleteKeyHandler(writer http.ResponseWriter, req *http.Request) {
+	id := req.PathValue("kid")
+
+	err := config.Keys.Retire(id)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	config.audit(req, requestActor(req).Id, AuditKeyRetire, "", 0, id)
+
+	writer.WriteHeader(204)
+}
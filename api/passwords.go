@@ -0,0 +1,154 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/PFrek/chirpy/db"
+	"github.com/PFrek/chirpy/mailer"
+)
+
+const passwordResetLifetime = 30 * time.Minute
+
+func (config *ApiConfig) sendPasswordResetEmail(user db.User) {
+	data := make([]byte, 32)
+	_, err := rand.Read(data)
+	if err != nil {
+		log.Printf("Failed to create password reset token for user %d: %v\n", user.Id, err)
+		return
+	}
+
+	token := hex.EncodeToString(data)
+	err = config.DB.CreateOneTimeToken(token, db.TokenPurposeResetPassword, user.Id, user.Email, passwordResetLifetime)
+	if err != nil {
+		log.Printf("Failed to store password reset token for user %d: %v\n", user.Id, err)
+		return
+	}
+
+	link := config.BaseURL + "/app/reset-password.html?token=" + url.QueryEscape(token)
+	err = config.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your Chirpy account. Choose a new one by opening the link below:\n\n%s\n\n"+
+			"The link expires in %d minutes and can only be used once. If you didn't ask for this, ignore this email.\n",
+			link, int(passwordResetLifetime.Minutes())),
+	})
+	if err != nil {
+		log.Printf("Failed to send password reset email to user %d: %v\n", user.Id, err)
+	}
+}
+
+// Emails a reset link if the address belongs to an account. The response is
+// the same either way, and the email is sent in the background so response
+// times don't tell registered addresses apart either.
+func (config *ApiConfig) PostForgotPasswordHandler(writer http.ResponseWriter, req *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	params := parameters{}
+	err := ExtractBody(&params, req)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	if wait := config.ResetRequestsByIP.Reserve(clientIP(req)); wait > 0 {
+		respondWithRetryAfter(writer, wait, "Too many password reset requests, try again later")
+		return
+	}
+
+	// Counted whether or not the address is registered, so being limited
+	// doesn't tell them apart either
+	if wait := config.ResetRequestsByAddress.Reserve(accountThrottleKey(params.Email)); wait > 0 {
+		respondWithRetryAfter(writer, wait, "Too many password reset requests, try again later")
+		return
+	}
+
+	user, err := config.DB.GetUserByEmail(params.Email)
+	if err == nil {
+		go config.sendPasswordResetEmail(user)
+	}
+
+	RespondWithJSON(writer, 202, struct {
+		Message string `json:"message"`
+	}{
+		Message: "If the address belongs to an account, a reset link has been sent to it",
+	})
+}
+
+// Sets a new password with a token from the reset email, logging out every
+// session of the account
+func (config *ApiConfig) PostResetPasswordHandler(writer http.ResponseWriter, req *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	params := parameters{}
+	err := ExtractBody(&params, req)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	// The token is only used up once the new password is accepted, so a
+	// rejected password doesn't cost the user their link
+	token, err := config.DB.GetOneTimeToken(params.Token, db.TokenPurposeResetPassword)
+	if err != nil {
+		RespondWithError(writer, 400, "Invalid or expired reset token")
+		return
+	}
+
+	user, err := config.DB.GetUserById(token.UserId)
+	if err != nil || user.Email != token.Email {
+		RespondWithError(writer, 400, "Invalid or expired reset token")
+		return
+	}
+
+	if !config.checkPasswordPolicy(writer, params.Password, user.Email) {
+		return
+	}
+
+	hashed, err := config.Passwords.Hash(params.Password)
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	_, err = config.DB.ConsumeOneTimeToken(params.Token, db.TokenPurposeResetPassword)
+	if err != nil {
+		RespondWithError(writer, 400, "Invalid or expired reset token")
+		return
+	}
+
+	_, err = config.DB.SetUserPassword(user.Id, hashed)
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	_, err = config.DB.RevokeAllSessions(user.Id)
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	// Following the emailed link proves the user owns the address
+	if !user.EmailVerified {
+		_, err = config.DB.VerifyUserEmail(user.Id, user.Email)
+		if err != nil {
+			log.Printf("Failed to verify email of user %d after password reset: %v\n", user.Id, err)
+		}
+	}
+
+	config.LoginThrottle.RecordSuccess(user.Email)
+	config.audit(req, user.Id, AuditPasswordReset, AuditTargetUser, user.Id, "")
+
+	writer.WriteHeader(204)
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/PFrek/chirpy/db"
+	"github.com/PFrek/chirpy/text"
+)
+
+func (config *ApiConfig) filterProfanity(body string) (text.FilterResult, error) {
+	dbRules, err := config.DB.GetProfanityRules()
+	if err != nil {
+		return text.FilterResult{}, err
+	}
+
+	rules := []text.Rule{}
+	for _, rule := range dbRules {
+		rules = append(rules, text.Rule{
+			Word:   rule.Word,
+			Action: rule.Action,
+		})
+	}
+
+	return text.Filter(body, rules), nil
+}
+
+func validateProfanityRule(word string, action string) error {
+	if len(word) == 0 || strings.ContainsFunc(word, unicode.IsSpace) {
+		return errors.New("Word must be a single non-empty word")
+	}
+
+	// Filter skips these, so the rule would never apply
+	if !text.CanMatch(word) {
+		return errors.New("Word must contain latin letters or look-alikes")
+	}
+
+	if !text.IsValidAction(action) {
+		return errors.New("Action must be one of: mask, reject, flag")
+	}
+
+	return nil
+}
+
+func (config *ApiConfig) GetProfanityRulesHandler(writer http.ResponseWriter, req *http.Request) {
+	rules, err := config.DB.GetProfanityRules()
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	RespondWithJSON(writer, 200, rules)
+}
+
+func (config *ApiConfig) PostProfanityRulesHandler(writer http.ResponseWriter, req *http.Request) {
+	type parameters struct {
+		Word   string `json:"word"`
+		Action string `json:"action"`
+	}
+
+	params := parameters{}
+	err := ExtractBody(&params, req)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	params.Word = strings.TrimSpace(params.Word)
+	if len(params.Action) == 0 {
+		params.Action = text.ActionMask
+	}
+
+	err = validateProfanityRule(params.Word, params.Action)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	rule, err := config.DB.CreateProfanityRule(params.Word, params.Action)
+	if err != nil {
+		if errors.Is(err, db.ExistingProfanityRuleError{}) {
+			RespondWithError(writer, 400, err.Error())
+			return
+		}
+
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	config.audit(req, requestActor(req).Id, AuditProfanityRuleSet, AuditTargetProfanityRule, rule.Id, rule.Word+": "+rule.Action)
+
+	RespondWithJSON(writer, 201, rule)
+}
+
+func (config *ApiConfig) PutProfanityRuleHandler(writer http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		RespondWithError(writer, 400, "Invalid [id] value in path")
+		return
+	}
+
+	type parameters struct {
+		Word   string `json:"word"`
+		Action string `json:"action"`
+	}
+
+	params := parameters{}
+	err = ExtractBody(&params, req)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	params.Word = strings.TrimSpace(params.Word)
+	err = validateProfanityRule(params.Word, params.Action)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	rule, err := config.DB.UpdateProfanityRule(db.ProfanityRule{
+		Id:     id,
+		Word:   params.Word,
+		Action: params.Action,
+	})
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{Model: "ProfanityRule"}) {
+			RespondWithError(writer, 404, "Not Found")
+			return
+		}
+		if errors.Is(err, db.ExistingProfanityRuleError{}) {
+			RespondWithError(writer, 400, err.Error())
+			return
+		}
+
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	config.audit(req, requestActor(req).Id, AuditProfanityRuleSet, AuditTargetProfanityRule, rule.Id, rule.Word+": "+rule.Action)
+
+	RespondWithJSON(writer, 200, rule)
+}
+
+func (config *ApiConfig) DeleteProfanityRuleHandler(writer http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		RespondWithError(writer, 400, "Invalid [id] value in path")
+		return
+	}
+
+	err = config.DB.DeleteProfanityRule(id)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{Model: "ProfanityRule"}) {
+			RespondWithError(writer, 404, "Not Found")
+			return
+		}
+
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	config.audit(req, requestActor(req).Id, AuditProfanityRuleDel, AuditTargetProfanityRule, id, "")
+
+	writer.WriteHeader(204)
+}
+
+func (config *ApiConfig) GetFlaggedChirpsHandler(writer http.ResponseWriter, req *http.Request) {
+	chirps, err := config.DB.GetFlaggedChirps()
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	RespondWithJSON(writer, 200, chirps)
+}
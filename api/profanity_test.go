@@ -0,0 +1,33 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/PFrek/chirpy/text"
+)
+
+func TestValidateProfanityRule(t *testing.T) {
+	tests := []struct {
+		name   string
+		word   string
+		action string
+		valid  bool
+	}{
+		{"valid", "fornax", text.ActionMask, true},
+		{"look-alikes", "ƒоrnах", text.ActionFlag, true},
+		{"empty", "", text.ActionMask, false},
+		{"several words", "two words", text.ActionMask, false},
+		{"punctuation only", "?!", text.ActionMask, false},
+		{"no latin letters", "日本", text.ActionReject, false},
+		{"unknown action", "fornax", "ban", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateProfanityRule(test.word, test.action)
+			if (err == nil) != test.valid {
+				t.Errorf("validateProfanityRule(%q, %q) error = %v, want valid %v", test.word, test.action, err, test.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PFrek/chirpy/db"
+	"github.com/PFrek/chirpy/text"
+)
+
+// Limits in characters, as counted by text.GraphemeCount
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+	maxLocationLength    = 30
+	maxWebsiteLength     = 100
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+
+func validateUsername(username string) error {
+	if !usernamePattern.MatchString(username) {
+		return errors.New("Username must be 3 to 30 letters, digits or underscores")
+	}
+
+	return nil
+}
+
+func validateWebsite(website string) error {
+	if len(website) > maxWebsiteLength {
+		return fmt.Errorf("Website must be at most %d characters", maxWebsiteLength)
+	}
+
+	parsed, err := url.Parse(website)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 {
+		return errors.New("Website must be an http or https URL")
+	}
+
+	return nil
+}
+
+// Optional profile fields of a request body, where nil leaves the field
+// unchanged
+type profileParams struct {
+	Username    *string `json:"username"`
+	DisplayName *string `json:"display_name"`
+	Bio         *string `json:"bio"`
+	Location    *string `json:"location"`
+	Website     *string `json:"website"`
+	// An attachment uploaded by the user, or 0 to remove the avatar
+	AvatarId *int `json:"avatar_id"`
+}
+
+// Validates the given fields and applies them to the user, returning the
+// names of the fields that changed
+func (config *ApiConfig) applyProfile(user *db.User, params profileParams) ([]string, error) {
+	changed := []string{}
+
+	if params.Username != nil && *params.Username != user.Username {
+		err := validateUsername(*params.Username)
+		if err != nil {
+			return nil, err
+		}
+
+		user.Username = *params.Username
+		changed = append(changed, "username")
+	}
+
+	// Free text fields go through the profanity filter like chirps do
+	textFields := []struct {
+		name      string
+		value     *string
+		field     *string
+		maxLength int
+	}{
+		{"display_name", params.DisplayName, &user.DisplayName, maxDisplayNameLength},
+		{"bio", params.Bio, &user.Bio, maxBioLength},
+		{"location", params.Location, &user.Location, maxLocationLength},
+	}
+
+	for _, textField := range textFields {
+		if textField.value == nil {
+			continue
+		}
+
+		value := strings.TrimSpace(*textField.value)
+		if text.GraphemeCount(value) > textField.maxLength {
+			return nil, fmt.Errorf("%s must be at most %d characters", textField.name, textField.maxLength)
+		}
+
+		filtered, err := config.filterProfanity(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if filtered.Rejected {
+			return nil, fmt.Errorf("%s contains prohibited language", textField.name)
+		}
+
+		if filtered.Text != *textField.field {
+			*textField.field = filtered.Text
+			changed = append(changed, textField.name)
+		}
+	}
+
+	if params.Website != nil && strings.TrimSpace(*params.Website) != user.Website {
+		website := strings.TrimSpace(*params.Website)
+		if len(website) > 0 {
+			err := validateWebsite(website)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		user.Website = website
+		changed = append(changed, "website")
+	}
+
+	if params.AvatarId != nil {
+		if *params.AvatarId == 0 {
+			if user.Avatar != nil {
+				user.Avatar = nil
+				changed = append(changed, "avatar")
+			}
+		} else {
+			attachments, err := config.resolveAttachments([]int{*params.AvatarId}, user.Id)
+			if err != nil {
+				return nil, err
+			}
+
+			user.Avatar = &attachments[0]
+			changed = append(changed, "avatar")
+		}
+	}
+
+	return changed, nil
+}
+
+func (config *ApiConfig) GetUserByUsernameHandler(writer http.ResponseWriter, req *http.Request) {
+	user, err := config.DB.GetUserByUsername(req.PathValue("name"))
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{Model: "User"}) {
+			RespondWithError(writer, 404, "Not Found")
+			return
+		}
+
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	RespondWithJSON(writer, 200, newResponseUser(user))
+}
@@ -0,0 +1,304 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PFrek/chirpy/db"
+	"github.com/PFrek/chirpy/text"
+)
+
+const maxReportDetailsLength = 1000
+
+const (
+	ReportActionDeleteChirp   = db.ReportActionDeleteChirp
+	ReportActionSuspendAuthor = db.ReportActionSuspendAuthor
+)
+
+func createReportFilters(req *http.Request) db.ReportFilter {
+	return db.ReportFilter{
+		Status:     extractQuery("status", req, nonEmptyString),
+		TargetType: extractQuery("target_type", req, nonEmptyString),
+	}
+}
+
+// Files a report on behalf of the system so the target shows up in the
+// moderation queue
+func (config *ApiConfig) fileAutomatedReport(targetType string, targetId int, details string) {
+	_, err := config.DB.CreateReport(db.Report{
+		TargetType: targetType,
+		TargetId:   targetId,
+		Reason:     db.ReportReasonAutomated,
+		Details:    details,
+	})
+	if err != nil {
+		log.Printf("Failed to file automated report on %s %d: %v", targetType, targetId, err)
+	}
+}
+
+func (config *ApiConfig) PostReportsHandler(writer http.ResponseWriter, req *http.Request) {
+	reporterId, err := config.AuthenticateRequest(req)
+	if err != nil {
+		RespondWithAuthError(writer, err)
+		return
+	}
+
+	reporter, err := config.DB.GetUserById(reporterId)
+	if err != nil {
+		RespondWithError(writer, 401, "Unauthorized")
+		return
+	}
+
+	if !config.requireVerifiedEmail(writer, reporter, VerifiedActionReport) {
+		return
+	}
+
+	type parameters struct {
+		TargetType string `json:"target_type"`
+		TargetId   int    `json:"target_id"`
+		Reason     string `json:"reason"`
+		Details    string `json:"details"`
+	}
+
+	params := parameters{}
+	err = ExtractBody(&params, req)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	if !slices.Contains(db.ReportReasons, params.Reason) {
+		RespondWithError(writer, 400, fmt.Sprintf("Reason must be one of: %s", strings.Join(db.ReportReasons, ", ")))
+		return
+	}
+
+	params.Details = strings.TrimSpace(params.Details)
+	if text.GraphemeCount(params.Details) > maxReportDetailsLength {
+		RespondWithError(writer, 400, "Details are too long")
+		return
+	}
+
+	switch params.TargetType {
+	case db.ReportTargetChirp:
+		chirp, err := config.DB.GetChirpById(params.TargetId)
+		if err != nil {
+			if errors.Is(err, db.NotFoundError{Model: "Chirp"}) {
+				RespondWithError(writer, 404, "Not Found")
+				return
+			}
+
+			RespondWithError(writer, 500, err.Error())
+			return
+		}
+
+		// Chirps the reporter can't see are treated as missing
+		visible, err := config.DB.CanViewChirp(chirp, reporterId)
+		if err != nil {
+			RespondWithError(writer, 500, err.Error())
+			return
+		}
+		if !visible {
+			RespondWithError(writer, 404, "Not Found")
+			return
+		}
+
+		if chirp.AuthorId == reporterId {
+			RespondWithError(writer, 400, "Cannot report your own chirp")
+			return
+		}
+	case db.ReportTargetUser:
+		if params.TargetId == reporterId {
+			RespondWithError(writer, 400, "Cannot report yourself")
+			return
+		}
+	default:
+		RespondWithError(writer, 400, "Target type must be one of: chirp, user")
+		return
+	}
+
+	report, err := config.DB.CreateReport(db.Report{
+		ReporterId: reporterId,
+		TargetType: params.TargetType,
+		TargetId:   params.TargetId,
+		Reason:     params.Reason,
+		Details:    params.Details,
+	})
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{Model: "Report target"}) {
+			RespondWithError(writer, 404, "Not Found")
+			return
+		}
+
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	RespondWithJSON(writer, 201, report)
+}
+
+func (config *ApiConfig) GetReportsHandler(writer http.ResponseWriter, req *http.Request) {
+	reports, err := config.DB.GetReports(createReportFilters(req))
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	RespondWithJSON(writer, 200, reports)
+}
+
+func (config *ApiConfig) GetReportHandler(writer http.ResponseWriter, req *http.Request) {
+	report, ok := config.getReportFromPath(writer, req)
+	if !ok {
+		return
+	}
+
+	// Include the reported content so moderators don't need another lookup.
+	// The target may have been deleted since the report was filed.
+	var target interface{}
+	switch report.TargetType {
+	case db.ReportTargetChirp:
+		chirp, err := config.DB.GetChirpById(report.TargetId)
+		if err == nil {
+			target = chirp
+		}
+	case db.ReportTargetUser:
+		user, err := config.DB.GetUserById(report.TargetId)
+		if err == nil {
+			target = newAdminResponseUser(user)
+		}
+	}
+
+	response := struct {
+		db.Report
+		Target interface{} `json:"target"`
+	}{
+		Report: report,
+		Target: target,
+	}
+
+	RespondWithJSON(writer, 200, response)
+}
+
+func (config *ApiConfig) PostReportDismissHandler(writer http.ResponseWriter, req *http.Request) {
+	report, ok := config.getReportFromPath(writer, req)
+	if !ok {
+		return
+	}
+
+	config.resolveReport(writer, req, report, db.ReportStatusDismissed, "dismissed")
+}
+
+func (config *ApiConfig) PostReportActionHandler(writer http.ResponseWriter, req *http.Request) {
+	report, ok := config.getReportFromPath(writer, req)
+	if !ok {
+		return
+	}
+
+	type parameters struct {
+		Action string `json:"action"`
+	}
+
+	params := parameters{}
+	err := ExtractBody(&params, req)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	switch params.Action {
+	case ReportActionDeleteChirp:
+		if report.TargetType != db.ReportTargetChirp {
+			RespondWithError(writer, 400, "Only chirp reports support this action")
+			return
+		}
+	case ReportActionSuspendAuthor:
+	default:
+		RespondWithError(writer, 400, fmt.Sprintf("Action must be one of: %s, %s", ReportActionDeleteChirp, ReportActionSuspendAuthor))
+		return
+	}
+
+	actor := requestActor(req)
+	reason := fmt.Sprintf("Report %d: %s", report.Id, report.Reason)
+	suspension := &db.Restriction{
+		Reason:    reason,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	// The report is checked to still be open and resolved along with the
+	// action, so only one moderator gets to act on it
+	resolved, targetId, err := config.DB.ActionReport(report.Id, actor.Id, params.Action, suspension, func(author db.User) bool {
+		return canRestrict(actor, author)
+	})
+	if err != nil {
+		var transitionErr db.ReportTransitionError
+		switch {
+		case errors.As(err, &transitionErr):
+			RespondWithError(writer, 409, "Report is not open")
+		case errors.Is(err, db.NotFoundError{Model: "Chirp"}):
+			RespondWithError(writer, 409, "Reported chirp no longer exists")
+		case errors.Is(err, db.NotFoundError{Model: "User"}):
+			RespondWithError(writer, 409, "Reported user no longer exists")
+		case errors.Is(err, db.ReportActionForbiddenError{}):
+			RespondWithError(writer, 403, "Forbidden")
+		default:
+			RespondWithError(writer, 500, err.Error())
+		}
+		return
+	}
+
+	if params.Action == ReportActionDeleteChirp {
+		config.audit(req, actor.Id, AuditChirpDelete, AuditTargetChirp, targetId, fmt.Sprintf("Report %d", report.Id))
+	} else {
+		config.audit(req, actor.Id, AuditSuspend, AuditTargetUser, targetId, reason)
+	}
+	config.audit(req, actor.Id, AuditReportResolve, AuditTargetReport, report.Id, fmt.Sprintf("%s: %s", db.ReportStatusActioned, params.Action))
+
+	RespondWithJSON(writer, 200, resolved)
+}
+
+func (config *ApiConfig) getReportFromPath(writer http.ResponseWriter, req *http.Request) (db.Report, bool) {
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		RespondWithError(writer, 400, "Invalid [id] value in path")
+		return db.Report{}, false
+	}
+
+	report, err := config.DB.GetReportById(id)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{Model: "Report"}) {
+			RespondWithError(writer, 404, "Not Found")
+			return db.Report{}, false
+		}
+
+		RespondWithError(writer, 500, err.Error())
+		return db.Report{}, false
+	}
+
+	return report, true
+}
+
+func (config *ApiConfig) resolveReport(writer http.ResponseWriter, req *http.Request, report db.Report, status string, resolution string) {
+	moderatorId := requestActor(req).Id
+
+	resolved, err := config.DB.ResolveReport(report.Id, status, moderatorId, resolution)
+	if err != nil {
+		var transitionErr db.ReportTransitionError
+		if errors.As(err, &transitionErr) {
+			RespondWithError(writer, 409, "Report is not open")
+			return
+		}
+
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	config.audit(req, moderatorId, AuditReportResolve, AuditTargetReport, report.Id, fmt.Sprintf("%s: %s", status, resolution))
+
+	RespondWithJSON(writer, 200, resolved)
+}
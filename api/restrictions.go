@@ -0,0 +1,205 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PFrek/chirpy/db"
+)
+
+type AdminResponseUser struct {
+	ResponseUser
+	Email         string          `json:"email"`
+	Role          string          `json:"role"`
+	EmailVerified bool            `json:"email_verified"`
+	Suspension    *db.Restriction `json:"suspension"`
+	Shadowban     *db.Restriction `json:"shadowban"`
+	TwoFactor     bool            `json:"two_factor_enabled"`
+}
+
+func newAdminResponseUser(user db.User) AdminResponseUser {
+	return AdminResponseUser{
+		ResponseUser:  newResponseUser(user),
+		Email:         user.Email,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerified,
+		Suspension:    user.Suspension,
+		Shadowban:     user.Shadowban,
+		TwoFactor:     user.HasTwoFactor(),
+	}
+}
+
+// Builds a restriction from the request body. An empty duration makes the
+// restriction last until it is lifted.
+func extractRestriction(req *http.Request) (*db.Restriction, error) {
+	type parameters struct {
+		Reason   string `json:"reason"`
+		Duration string `json:"duration"`
+	}
+
+	params := parameters{}
+	err := ExtractBody(&params, req)
+	if err != nil {
+		return nil, err
+	}
+
+	restriction := &db.Restriction{
+		Reason:    strings.TrimSpace(params.Reason),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if len(params.Duration) > 0 {
+		duration, err := time.ParseDuration(params.Duration)
+		if err != nil || duration <= 0 {
+			return nil, errors.New("Duration must be a positive duration such as 72h")
+		}
+
+		expiresAt := restriction.CreatedAt.Add(duration)
+		restriction.ExpiresAt = &expiresAt
+	}
+
+	return restriction, nil
+}
+
+// Moderators can't restrict their peers or superiors
+func canRestrict(actor db.User, target db.User) bool {
+	return actor.Role == db.RoleAdmin || !target.HasRole(actor.Role)
+}
+
+type restrictionSetter func(id int, restriction *db.Restriction) (db.User, error)
+
+func (config *ApiConfig) setRestriction(writer http.ResponseWriter, req *http.Request, set restrictionSetter, lift bool, action string) {
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		RespondWithError(writer, 400, "Invalid [id] value in path")
+		return
+	}
+
+	target, err := config.DB.GetUserById(id)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{Model: "User"}) {
+			RespondWithError(writer, 404, "Not Found")
+			return
+		}
+
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	actor := requestActor(req)
+	if !canRestrict(actor, target) {
+		RespondWithError(writer, 403, "Forbidden")
+		return
+	}
+
+	var restriction *db.Restriction
+	if !lift {
+		restriction, err = extractRestriction(req)
+		if err != nil {
+			RespondWithError(writer, 400, err.Error())
+			return
+		}
+	}
+
+	user, err := set(id, restriction)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{Model: "User"}) {
+			RespondWithError(writer, 404, "Not Found")
+			return
+		}
+
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	details := ""
+	if restriction != nil {
+		details = restriction.Reason
+		if restriction.ExpiresAt != nil {
+			details += fmt.Sprintf(" (until %s)", restriction.ExpiresAt.Format(time.RFC3339))
+		}
+	}
+	config.audit(req, actor.Id, action, AuditTargetUser, id, details)
+
+	RespondWithJSON(writer, 200, newAdminResponseUser(user))
+}
+
+func (config *ApiConfig) PostSuspensionHandler(writer http.ResponseWriter, req *http.Request) {
+	config.setRestriction(writer, req, config.DB.SetUserSuspension, false, AuditSuspend)
+}
+
+func (config *ApiConfig) DeleteSuspensionHandler(writer http.ResponseWriter, req *http.Request) {
+	config.setRestriction(writer, req, config.DB.SetUserSuspension, true, AuditUnsuspend)
+}
+
+func (config *ApiConfig) PostShadowbanHandler(writer http.ResponseWriter, req *http.Request) {
+	config.setRestriction(writer, req, config.DB.SetUserShadowban, false, AuditShadowban)
+}
+
+func (config *ApiConfig) DeleteShadowbanHandler(writer http.ResponseWriter, req *http.Request) {
+	config.setRestriction(writer, req, config.DB.SetUserShadowban, true, AuditUnshadowban)
+}
+
+func (config *ApiConfig) GetAdminUserHandler(writer http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		RespondWithError(writer, 400, "Invalid [id] value in path")
+		return
+	}
+
+	user, err := config.DB.GetUserById(id)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{Model: "User"}) {
+			RespondWithError(writer, 404, "Not Found")
+			return
+		}
+
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	RespondWithJSON(writer, 200, newAdminResponseUser(user))
+}
+
+func (config *ApiConfig) PutUserRoleHandler(writer http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		RespondWithError(writer, 400, "Invalid [id] value in path")
+		return
+	}
+
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	params := parameters{}
+	err = ExtractBody(&params, req)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	if !db.IsValidRole(params.Role) {
+		RespondWithError(writer, 400, "Role must be one of: user, moderator, admin")
+		return
+	}
+
+	user, err := config.DB.SetUserRole(id, params.Role)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{Model: "User"}) {
+			RespondWithError(writer, 404, "Not Found")
+			return
+		}
+
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	config.audit(req, requestActor(req).Id, AuditRoleChange, AuditTargetUser, id, params.Role)
+
+	RespondWithJSON(writer, 200, newAdminResponseUser(user))
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/PFrek/chirpy/db"
+)
+
+type ResponseSession struct {
+	db.Session
+	// Whether the request was made with a token from this session
+	Current bool `json:"current"`
+}
+
+func (config *ApiConfig) GetSessionsHandler(writer http.ResponseWriter, req *http.Request) {
+	user, claims, err := config.authenticate(req)
+	if err != nil {
+		RespondWithAuthError(writer, err)
+		return
+	}
+
+	sessions, err := config.DB.GetSessions(user.Id)
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	response := []ResponseSession{}
+	for _, session := range sessions {
+		response = append(response, ResponseSession{
+			Session: session,
+			Current: session.Id == claims.SessionId,
+		})
+	}
+
+	RespondWithJSON(writer, 200, response)
+}
+
+// Revokes one of the caller's sessions, along with the access tokens issued
+// for it
+func (config *ApiConfig) DeleteSessionHandler(writer http.ResponseWriter, req *http.Request) {
+	userId, err := config.AuthenticateRequest(req)
+	if err != nil {
+		RespondWithAuthError(writer, err)
+		return
+	}
+
+	sessionId := req.PathValue("id")
+	err = config.DB.RevokeSession(userId, sessionId)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{Model: "Session"}) {
+			RespondWithError(writer, 404, "Not Found")
+			return
+		}
+
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	config.audit(req, userId, AuditSessionRevoke, AuditTargetUser, userId, "Revoked session "+sessionId)
+
+	writer.WriteHeader(204)
+}
+
+// Revokes every session of the caller and invalidates all their access
+// tokens, including the one used for this request
+func (config *ApiConfig) PostLogoutAllHandler(writer http.ResponseWriter, req *http.Request) {
+	userId, err := config.AuthenticateRequest(req)
+	if err != nil {
+		RespondWithAuthError(writer, err)
+		return
+	}
+
+	_, err = config.DB.RevokeAllSessions(userId)
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	config.audit(req, userId, AuditLogoutAll, AuditTargetUser, userId, "")
+
+	writer.WriteHeader(204)
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PFrek/chirpy/db"
+	"github.com/PFrek/chirpy/keyring"
+	"github.com/PFrek/chirpy/passhash"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestConfig(t *testing.T) *ApiConfig {
+	t.Helper()
+
+	dir := t.TempDir()
+	database, err := db.NewDB(filepath.Join(dir, "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = database.SetAuditSigning([]byte("test-audit-key"), filepath.Join(dir, "audit.head"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := keyring.Load(filepath.Join(dir, "keys"), keyring.AlgorithmEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ApiConfig{
+		DB:            database,
+		Keys:          keys,
+		JWT:           JWTConfig{Audience: "chirpy-test"},
+		LoginThrottle: NewLoginThrottle(DefaultLoginThrottleConfig()),
+		// Cheap parameters so the tests run quickly
+		Passwords: passhash.Config{Algorithm: passhash.AlgorithmArgon2id, Argon2Memory: 64, Argon2Time: 1, Argon2Threads: 1},
+	}
+
+	err = config.HashDummyPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return config
+}
+
+// Creates a user with a session, returning its access and refresh tokens
+func newTestSession(t *testing.T, config *ApiConfig, email string) (db.User, string, string) {
+	t.Helper()
+
+	user, err := config.DB.CreateUser(email, "unused", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refresh, sessionId, err := config.generateRefreshToken(user.Id, httptest.NewRequest("POST", "/api/login", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := config.generateJWTToken(user, sessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user, token, refresh
+}
+
+func serve(handler http.HandlerFunc, method string, target string, bearer string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Authorization", "Bearer "+bearer)
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, req)
+	return recorder
+}
+
+func TestSessionsRejectSuspendedUsers(t *testing.T) {
+	config := newTestConfig(t)
+	user, token, refresh := newTestSession(t, config, "user@example.com")
+
+	expiresAt := time.Now().UTC().Add(time.Hour)
+	_, err := config.DB.SetUserSuspension(user.Id, &db.Restriction{Reason: "test", CreatedAt: time.Now().UTC(), ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		target  string
+		bearer  string
+	}{
+		{"list sessions", config.GetSessionsHandler, "GET", "/api/sessions", token},
+		{"revoke session", config.DeleteSessionHandler, "DELETE", "/api/sessions/any", token},
+		{"logout everywhere", config.PostLogoutAllHandler, "POST", "/api/logout-all", token},
+		{"refresh", config.PostRefreshHandler, "POST", "/api/refresh", refresh},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serve(test.handler, test.method, test.target, test.bearer)
+			if recorder.Code != 403 {
+				t.Fatalf("status = %d, want 403", recorder.Code)
+			}
+
+			body := struct {
+				Code string `json:"code"`
+			}{}
+			json.NewDecoder(recorder.Body).Decode(&body)
+			if body.Code != "account_suspended" {
+				t.Errorf("code = %q, want account_suspended", body.Code)
+			}
+		})
+	}
+
+	// The refresh token wasn't used up while suspended
+	_, err = config.DB.SetUserSuspension(user.Id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(config.PostRefreshHandler, "POST", "/api/refresh", refresh)
+	if recorder.Code != 200 {
+		t.Errorf("refresh after the suspension status = %d, want 200", recorder.Code)
+	}
+
+	recorder = serve(config.GetSessionsHandler, "GET", "/api/sessions", token)
+	if recorder.Code != 200 {
+		t.Errorf("listing sessions after the suspension status = %d, want 200", recorder.Code)
+	}
+}
+
+func TestRevokedSessionRejectsAccessTokens(t *testing.T) {
+	config := newTestConfig(t)
+	user, token, _ := newTestSession(t, config, "user@example.com")
+
+	// A second session of the same user to revoke the first one from
+	_, otherSession, err := config.generateRefreshToken(user.Id, httptest.NewRequest("POST", "/api/login", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := config.generateJWTToken(user, otherSession)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := &ChirpyClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(token, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("DELETE", "/api/sessions/"+claims.SessionId, nil)
+	req.SetPathValue("id", claims.SessionId)
+	req.Header.Set("Authorization", "Bearer "+otherToken)
+	recorder := httptest.NewRecorder()
+	config.DeleteSessionHandler(recorder, req)
+	if recorder.Code != 204 {
+		t.Fatalf("revoking the session status = %d, want 204", recorder.Code)
+	}
+
+	assertRevoked := func() {
+		t.Helper()
+
+		recorder := serve(config.GetSessionsHandler, "GET", "/api/sessions", token)
+		if recorder.Code != 401 {
+			t.Fatalf("status with the revoked session's token = %d, want 401", recorder.Code)
+		}
+
+		body := struct {
+			Code string `json:"code"`
+		}{}
+		json.NewDecoder(recorder.Body).Decode(&body)
+		if body.Code != AuthErrorRevoked {
+			t.Errorf("code = %q, want %s", body.Code, AuthErrorRevoked)
+		}
+
+		recorder = serve(config.GetSessionsHandler, "GET", "/api/sessions", otherToken)
+		if recorder.Code != 200 {
+			t.Errorf("status with the other session's token = %d, want 200", recorder.Code)
+		}
+	}
+	assertRevoked()
+
+	// Still revoked once the janitor deleted the session's tokens
+	_, err = config.DB.DeleteStaleRefreshTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRevoked()
+}
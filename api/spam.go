@@ -0,0 +1,151 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PFrek/chirpy/db"
+	"github.com/PFrek/chirpy/text"
+)
+
+type SpamConfig struct {
+	// Posting beyond these rates is rejected with 429
+	MaxChirpsPerMinute int
+	MaxChirpsPerHour   int
+	// Reposting the same body within this window is rejected with 422
+	DuplicateWindow time.Duration
+	// Accounts younger than this are treated with more suspicion
+	NewAccountAge time.Duration
+	// Chirps scoring at least HoldScore are hidden until reviewed, and those
+	// scoring at least RejectScore are rejected with 422
+	HoldScore   int
+	RejectScore int
+}
+
+func DefaultSpamConfig() SpamConfig {
+	return SpamConfig{
+		MaxChirpsPerMinute: 5,
+		MaxChirpsPerHour:   60,
+		DuplicateWindow:    24 * time.Hour,
+		NewAccountAge:      24 * time.Hour,
+		HoldScore:          3,
+		RejectScore:        5,
+	}
+}
+
+const (
+	SpamVerdictAllow = iota
+	SpamVerdictHold
+	SpamVerdictReject
+	SpamVerdictThrottle
+)
+
+type SpamVerdict struct {
+	Verdict int
+	Score   int
+	Reasons []string
+	// Set for SpamVerdictThrottle
+	RetryAfter time.Duration
+}
+
+// Collapses case and whitespace so trivially altered reposts still count as
+// duplicates
+func spamFingerprint(body string) string {
+	return strings.Join(strings.Fields(strings.ToLower(body)), " ")
+}
+
+// Returned by the chirp creation check for throttled and rejected chirps
+type SpamRejectedError struct {
+	Verdict SpamVerdict
+}
+
+func (err SpamRejectedError) Error() string {
+	return fmt.Sprintf("Chirp rejected as spam: %s", strings.Join(err.Verdict.Reasons, ", "))
+}
+
+// How far back scoreChirp needs to look at the author's chirps
+func (config *ApiConfig) spamLookback() time.Duration {
+	return max(time.Hour, config.Spam.DuplicateWindow)
+}
+
+// Scores the body of a new chirp, which must already be filtered the same way
+// stored chirps are, against the author's chirps from the last spamLookback
+func (config *ApiConfig) scoreChirp(author db.User, body string, recent []db.Chirp) SpamVerdict {
+	spam := config.Spam
+	now := time.Now().UTC()
+
+	verdict := SpamVerdict{Reasons: []string{}}
+
+	// Posting velocity
+	lastMinute, lastHour := []db.Chirp{}, []db.Chirp{}
+	for _, chirp := range recent {
+		if chirp.CreatedAt.After(now.Add(-time.Minute)) {
+			lastMinute = append(lastMinute, chirp)
+		}
+		if chirp.CreatedAt.After(now.Add(-time.Hour)) {
+			lastHour = append(lastHour, chirp)
+		}
+	}
+
+	if spam.MaxChirpsPerMinute > 0 && len(lastMinute) >= spam.MaxChirpsPerMinute {
+		verdict.Verdict = SpamVerdictThrottle
+		verdict.RetryAfter = lastMinute[0].CreatedAt.Add(time.Minute).Sub(now)
+		verdict.Reasons = append(verdict.Reasons, "Too many chirps in the last minute")
+		return verdict
+	}
+
+	if spam.MaxChirpsPerHour > 0 && len(lastHour) >= spam.MaxChirpsPerHour {
+		verdict.Verdict = SpamVerdictThrottle
+		verdict.RetryAfter = lastHour[0].CreatedAt.Add(time.Hour).Sub(now)
+		verdict.Reasons = append(verdict.Reasons, "Too many chirps in the last hour")
+		return verdict
+	}
+
+	if spam.MaxChirpsPerMinute > 1 && len(lastMinute) >= spam.MaxChirpsPerMinute/2 {
+		verdict.Score++
+		verdict.Reasons = append(verdict.Reasons, "High posting velocity")
+	}
+
+	// Duplicate bodies
+	fingerprint := spamFingerprint(body)
+	for _, chirp := range recent {
+		if chirp.CreatedAt.After(now.Add(-spam.DuplicateWindow)) && spamFingerprint(chirp.Body) == fingerprint {
+			verdict.Verdict = SpamVerdictReject
+			verdict.Reasons = append(verdict.Reasons, "Duplicate of a recent chirp")
+			return verdict
+		}
+	}
+
+	// Link density
+	links := len(text.FindURLs(body))
+	words := len(strings.Fields(body))
+	if links >= 3 {
+		verdict.Score += 2
+		verdict.Reasons = append(verdict.Reasons, fmt.Sprintf("Contains %d links", links))
+	} else if links > 0 && links*2 >= words {
+		verdict.Score++
+		verdict.Reasons = append(verdict.Reasons, "Mostly links")
+	}
+
+	// Account age, unknown for accounts created before it was recorded
+	isNew := !author.CreatedAt.IsZero() && author.CreatedAt.After(now.Add(-spam.NewAccountAge))
+	if isNew {
+		verdict.Score++
+		verdict.Reasons = append(verdict.Reasons, "New account")
+
+		if links > 0 {
+			verdict.Score++
+			verdict.Reasons = append(verdict.Reasons, "New account posting links")
+		}
+	}
+
+	switch {
+	case spam.RejectScore > 0 && verdict.Score >= spam.RejectScore:
+		verdict.Verdict = SpamVerdictReject
+	case spam.HoldScore > 0 && verdict.Score >= spam.HoldScore:
+		verdict.Verdict = SpamVerdictHold
+	}
+
+	return verdict
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type LoginThrottleConfig struct {
+	// Failures allowed before lockouts start, per account and per IP. IPs
+	// get more leeway since many users can share one.
+	MaxAccountFailures int
+	MaxIPFailures      int
+	// The first lockout lasts BaseLockout and each further failure doubles
+	// it, up to MaxLockout
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+	// Failures are forgotten after this long without a new one
+	ResetAfter time.Duration
+}
+
+func DefaultLoginThrottleConfig() LoginThrottleConfig {
+	return LoginThrottleConfig{
+		MaxAccountFailures: 5,
+		MaxIPFailures:      20,
+		BaseLockout:        30 * time.Second,
+		MaxLockout:         30 * time.Minute,
+		ResetAfter:         time.Hour,
+	}
+}
+
+type loginFailures struct {
+	count       int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Tracks failed logins in memory. Restarting the server clears all
+// lockouts, which is fine for slowing down guessing.
+type LoginThrottle struct {
+	config    LoginThrottleConfig
+	mu        sync.Mutex
+	failures  map[string]*loginFailures
+	lastPrune time.Time
+}
+
+func NewLoginThrottle(config LoginThrottleConfig) *LoginThrottle {
+	return &LoginThrottle{
+		config:   config,
+		failures: map[string]*loginFailures{},
+	}
+}
+
+func accountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Counts an attempt as a failure of the account and IP before the
+// credentials are checked, so concurrent guesses can't all get past a
+// lockout that hasn't been recorded yet. Returns how long until they may
+// try again instead, or 0 if the attempt may go ahead. Attempts that turn
+// out to be correct must be given back with Release.
+func (throttle *LoginThrottle) Reserve(email string, ip string) time.Duration {
+	throttle.mu.Lock()
+	defer throttle.mu.Unlock()
+
+	now := time.Now()
+	wait := time.Duration(0)
+	for _, key := range []string{accountThrottleKey(email), ipThrottleKey(ip)} {
+		failures, ok := throttle.failures[key]
+		if ok && failures.lockedUntil.After(now) {
+			wait = max(wait, failures.lockedUntil.Sub(now))
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	throttle.prune(now)
+
+	throttle.recordFailure(accountThrottleKey(email), throttle.config.MaxAccountFailures, now)
+	throttle.recordFailure(ipThrottleKey(ip), throttle.config.MaxIPFailures, now)
+	return 0
+}
+
+// Takes back the failure counted by Reserve once the credentials turned out
+// to be correct
+func (throttle *LoginThrottle) Release(email string, ip string) {
+	throttle.mu.Lock()
+	defer throttle.mu.Unlock()
+
+	throttle.release(accountThrottleKey(email), throttle.config.MaxAccountFailures)
+	throttle.release(ipThrottleKey(ip), throttle.config.MaxIPFailures)
+}
+
+func (throttle *LoginThrottle) release(key string, allowed int) {
+	failures, ok := throttle.failures[key]
+	if !ok || failures.count == 0 {
+		return
+	}
+
+	failures.count--
+	// The lockout was only caused by the released attempt
+	if failures.count <= allowed {
+		failures.lockedUntil = time.Time{}
+	}
+}
+
+func (throttle *LoginThrottle) recordFailure(key string, allowed int, now time.Time) {
+	failures, ok := throttle.failures[key]
+	if !ok || now.Sub(failures.lastFailure) > throttle.config.ResetAfter {
+		failures = &loginFailures{}
+		throttle.failures[key] = failures
+	}
+
+	failures.count++
+	failures.lastFailure = now
+
+	excess := failures.count - allowed
+	if excess <= 0 {
+		return
+	}
+
+	lockout := throttle.config.BaseLockout
+	for i := 1; i < excess && lockout < throttle.config.MaxLockout; i++ {
+		lockout *= 2
+	}
+	lockout = min(lockout, throttle.config.MaxLockout)
+
+	failures.lockedUntil = now.Add(lockout)
+	log.Printf("Login locked out for %s after %d failures, for %s\n", key, failures.count, lockout)
+}
+
+// Clears the account's failures after a successful login. The IP's are
+// kept so an attacker can't reset them with an account of their own.
+func (throttle *LoginThrottle) RecordSuccess(email string) {
+	throttle.mu.Lock()
+	defer throttle.mu.Unlock()
+
+	delete(throttle.failures, accountThrottleKey(email))
+}
+
+// Drops forgotten failures, at most once a minute
+func (throttle *LoginThrottle) prune(now time.Time) {
+	if now.Sub(throttle.lastPrune) < time.Minute {
+		return
+	}
+	throttle.lastPrune = now
+
+	for key, failures := range throttle.failures {
+		if now.Sub(failures.lastFailure) > throttle.config.ResetAfter && failures.lockedUntil.Before(now) {
+			delete(throttle.failures, key)
+		}
+	}
+}
+
+func respondWithLockout(writer http.ResponseWriter, wait time.Duration) {
+	respondWithRetryAfter(writer, wait, "Too many failed login attempts, try again later")
+}
+
+func respondWithRetryAfter(writer http.ResponseWriter, wait time.Duration, message string) {
+	writer.Header().Set("Retry-After", fmt.Sprint(int(wait.Seconds())+1))
+	RespondWithError(writer, 429, message)
+}
+
+// Allows at most max requests per key within a sliding window, such as
+// emails sent to one user. Like LoginThrottle it only lives in memory.
+type RateLimiter struct {
+	max       int
+	window    time.Duration
+	mu        sync.Mutex
+	requests  map[string][]time.Time
+	lastPrune time.Time
+}
+
+func NewRateLimiter(max int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		max:      max,
+		window:   window,
+		requests: map[string][]time.Time{},
+	}
+}
+
+// Counts a request for the key and returns 0 if it is within the limit,
+// otherwise returns how long until the next one will be
+func (limiter *RateLimiter) Reserve(key string) time.Duration {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := time.Now()
+	limiter.prune(now)
+
+	requests := limiter.recent(key, now)
+	if len(requests) >= limiter.max {
+		return requests[0].Add(limiter.window).Sub(now)
+	}
+
+	limiter.requests[key] = append(requests, now)
+	return 0
+}
+
+// Returns the key's requests still within the window, oldest first
+func (limiter *RateLimiter) recent(key string, now time.Time) []time.Time {
+	requests := limiter.requests[key]
+	for len(requests) > 0 && now.Sub(requests[0]) >= limiter.window {
+		requests = requests[1:]
+	}
+
+	return requests
+}
+
+// Drops keys without recent requests, at most once a minute
+func (limiter *RateLimiter) prune(now time.Time) {
+	if now.Sub(limiter.lastPrune) < time.Minute {
+		return
+	}
+	limiter.lastPrune = now
+
+	for key := range limiter.requests {
+		if len(limiter.recent(key, now)) == 0 {
+			delete(limiter.requests, key)
+		}
+	}
+}
+
+// Generates the hash logins compare against when the email is unknown, with
+// the same settings as real ones. Must be called once Passwords is set and
+// before serving logins.
+func (config *ApiConfig) HashDummyPassword() error {
+	hash, err := config.Passwords.Hash("chirpy-dummy-password")
+	if err != nil {
+		return fmt.Errorf("Unable to hash the dummy password: %v", err)
+	}
+
+	config.dummyPasswordHash = hash
+	return nil
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoginThrottleConcurrentGuesses(t *testing.T) {
+	config := DefaultLoginThrottleConfig()
+	throttle := NewLoginThrottle(config)
+
+	// Every guess is wrong, so none of them is released
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if throttle.Reserve("user@example.com", "127.0.0.1") == 0 {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The guess that causes the lockout still goes ahead
+	if got := int(allowed.Load()); got != config.MaxAccountFailures+1 {
+		t.Errorf("%d guesses went ahead, want %d", got, config.MaxAccountFailures+1)
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	config := LoginThrottleConfig{
+		MaxAccountFailures: 2,
+		MaxIPFailures:      4,
+		BaseLockout:        time.Minute,
+		MaxLockout:         time.Hour,
+		ResetAfter:         time.Hour,
+	}
+
+	// Each step reserves an attempt for an account from one IP, then
+	// releases it or marks the account's login successful
+	type step struct {
+		email   string
+		release bool
+		success bool
+		// Whether the attempt is allowed to go ahead
+		want bool
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "account lockout",
+			steps: []step{
+				{email: "a", want: true},
+				{email: "a", want: true},
+				{email: "a", want: true},
+				{email: "a", want: false},
+				{email: "b", want: true},
+			},
+		},
+		{
+			name: "correct attempts are released",
+			steps: []step{
+				{email: "a", want: true},
+				{email: "a", want: true, release: true},
+				{email: "a", want: true, release: true},
+				{email: "a", want: true},
+				{email: "a", want: true},
+				{email: "a", want: false},
+			},
+		},
+		{
+			name: "releasing the locking attempt lifts the lockout",
+			steps: []step{
+				{email: "a", want: true},
+				{email: "a", want: true},
+				{email: "a", want: true, release: true},
+				{email: "a", want: true},
+			},
+		},
+		{
+			name: "success clears the account",
+			steps: []step{
+				{email: "a", want: true},
+				{email: "a", want: true, release: true, success: true},
+				{email: "a", want: true},
+				{email: "a", want: true},
+				{email: "a", want: true},
+				{email: "a", want: false},
+			},
+		},
+		{
+			name: "ip lockout",
+			steps: []step{
+				{email: "a", want: true},
+				{email: "b", want: true},
+				{email: "c", want: true},
+				{email: "d", want: true},
+				{email: "e", want: true},
+				{email: "f", want: false},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			throttle := NewLoginThrottle(config)
+			for i, step := range test.steps {
+				wait := throttle.Reserve(step.email, "127.0.0.1")
+				if (wait == 0) != step.want {
+					t.Fatalf("step %d: Reserve(%s) = %s, want allowed %v", i, step.email, wait, step.want)
+				}
+
+				if step.release {
+					throttle.Release(step.email, "127.0.0.1")
+				}
+				if step.success {
+					throttle.RecordSuccess(step.email)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(2, time.Hour)
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"a", true},
+		{"a", true},
+		{"a", false},
+		{"b", true},
+		{"a", false},
+	}
+
+	for i, test := range tests {
+		wait := limiter.Reserve(test.key)
+		if (wait == 0) != test.want {
+			t.Errorf("request %d: Reserve(%s) = %s, want allowed %v", i, test.key, wait, test.want)
+		}
+		if wait > time.Hour {
+			t.Errorf("request %d: Reserve(%s) = %s, longer than the window", i, test.key, wait)
+		}
+	}
+
+	// Requests leave the window after it has passed
+	limiter = NewRateLimiter(1, 10*time.Millisecond)
+	if limiter.Reserve("a") != 0 {
+		t.Fatal("first request was limited")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if wait := limiter.Reserve("a"); wait != 0 {
+		t.Errorf("Reserve() after the window = %s, want 0", wait)
+	}
+}
@@ -0,0 +1,364 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PFrek/chirpy/db"
+	"github.com/PFrek/chirpy/keyring"
+	"github.com/PFrek/chirpy/totp"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// Audience of challenge tokens, so they can't be used as access tokens
+	twoFactorAudience  = "chirpy-2fa"
+	twoFactorChallenge = 5 * time.Minute
+	recoveryCodeCount  = 10
+)
+
+// Returns recovery codes of 80 random bits each, formatted as
+// xxxx-xxxx-xxxx-xxxx
+func newRecoveryCodes() ([]string, error) {
+	codes := []string{}
+	for range recoveryCodeCount {
+		data := make([]byte, 10)
+		_, err := rand.Read(data)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(data))
+		codes = append(codes, code[:4]+"-"+code[4:8]+"-"+code[8:12]+"-"+code[12:])
+	}
+
+	return codes, nil
+}
+
+type twoFactorClaims struct {
+	// Token version of the user when the challenge was issued, so logging
+	// out everywhere also cancels pending logins
+	TokenVersion int `json:"ver"`
+	jwt.RegisteredClaims
+}
+
+// Issues a challenge token for the second step of logging in. Its id is
+// stored so it can only complete one login.
+func (config *ApiConfig) respondWithTwoFactorChallenge(writer http.ResponseWriter, user db.User) {
+	data := make([]byte, 16)
+	_, err := rand.Read(data)
+	if err != nil {
+		RespondWithError(writer, 500, "Failed to create challenge token")
+		return
+	}
+	tokenId := hex.EncodeToString(data)
+
+	now := time.Now().UTC()
+	claims := &twoFactorClaims{
+		TokenVersion: user.TokenVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    jwtIssuer,
+			Audience:  jwt.ClaimStrings{twoFactorAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(twoFactorChallenge)),
+			Subject:   fmt.Sprint(user.Id),
+			ID:        tokenId,
+		},
+	}
+
+	challenge, err := config.Keys.Sign(claims)
+	if err != nil {
+		RespondWithError(writer, 500, "Failed to create challenge token")
+		return
+	}
+
+	err = config.DB.CreateOneTimeToken(tokenId, db.TokenPurposeTwoFactorChallenge, user.Id, user.Email, twoFactorChallenge)
+	if err != nil {
+		RespondWithError(writer, 500, "Failed to create challenge token")
+		return
+	}
+
+	RespondWithJSON(writer, 200, struct {
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		ChallengeToken    string `json:"challenge_token"`
+	}{
+		TwoFactorRequired: true,
+		ChallengeToken:    challenge,
+	})
+}
+
+// Returns the id of the user a challenge token was issued to, along with
+// the token's claims
+func (config *ApiConfig) parseChallengeToken(challenge string) (int, *twoFactorClaims, error) {
+	claims := &twoFactorClaims{}
+	_, err := jwt.ParseWithClaims(challenge, claims, config.Keys.Keyfunc,
+		jwt.WithValidMethods([]string{keyring.AlgorithmEdDSA, keyring.AlgorithmRS256}),
+		jwt.WithIssuer(jwtIssuer),
+		jwt.WithAudience(twoFactorAudience),
+		jwt.WithLeeway(config.JWT.Leeway),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return 0, nil, newTokenError(err)
+	}
+
+	id, err := strconv.Atoi(claims.Subject)
+	if err != nil || len(claims.ID) == 0 {
+		return 0, nil, AuthError{Code: AuthErrorInvalidClaims, Message: "Token has an invalid subject or id"}
+	}
+
+	return id, claims, nil
+}
+
+func extractTwoFactorCode(req *http.Request) (string, error) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	params := parameters{}
+	err := ExtractBody(&params, req)
+	if err != nil {
+		return "", err
+	}
+
+	if len(params.Code) == 0 {
+		return "", errors.New("Missing code")
+	}
+
+	return params.Code, nil
+}
+
+// Second step of logging in for users with two-factor enabled: exchanges
+// the challenge token and a TOTP or recovery code for the usual tokens
+func (config *ApiConfig) PostLoginTwoFactorHandler(writer http.ResponseWriter, req *http.Request) {
+	type parameters struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+
+	params := parameters{}
+	err := ExtractBody(&params, req)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	id, claims, err := config.parseChallengeToken(params.ChallengeToken)
+	if err != nil {
+		RespondWithAuthError(writer, err)
+		return
+	}
+
+	user, err := config.DB.GetUserById(id)
+	if err != nil {
+		RespondWithError(writer, 401, "Unauthorized")
+		return
+	}
+
+	if claims.TokenVersion != user.TokenVersion {
+		RespondWithAuthError(writer, AuthError{Code: AuthErrorRevoked, Message: "Token has been revoked"})
+		return
+	}
+
+	// Codes are only six digits, so guessing them is throttled like passwords
+	ip := clientIP(req)
+	if wait := config.LoginThrottle.Reserve(user.Email, ip); wait > 0 {
+		respondWithLockout(writer, wait)
+		return
+	}
+
+	// The challenge is used up before the code is checked, so concurrent
+	// guesses can't share it and a wrong code means logging in again
+	token, err := config.DB.ConsumeOneTimeToken(claims.ID, db.TokenPurposeTwoFactorChallenge)
+	if err != nil || token.UserId != user.Id {
+		RespondWithAuthError(writer, AuthError{Code: AuthErrorRevoked, Message: "Token has already been used"})
+		return
+	}
+
+	err = config.DB.VerifyTwoFactorCode(id, params.Code)
+	if err != nil {
+		if errors.Is(err, db.InvalidTwoFactorCodeError{}) || errors.Is(err, db.NotFoundError{Model: "TwoFactor"}) {
+			config.audit(req, 0, AuditLoginFailed, AuditTargetUser, id, "Wrong two-factor code")
+			RespondWithError(writer, 401, "Invalid two-factor code")
+			return
+		}
+
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+	config.LoginThrottle.Release(user.Email, ip)
+
+	if user.IsSuspended() {
+		RespondWithAuthError(writer, SuspendedError{ExpiresAt: user.Suspension.ExpiresAt})
+		return
+	}
+
+	config.LoginThrottle.RecordSuccess(user.Email)
+	config.respondWithLogin(writer, req, user)
+}
+
+// Starts enrollment, returning the secret to add to an authenticator app.
+// Needs the current password, and two-factor only takes effect once a code
+// is verified.
+func (config *ApiConfig) PostTwoFactorEnrollHandler(writer http.ResponseWriter, req *http.Request) {
+	user, _, err := config.authenticate(req)
+	if err != nil {
+		RespondWithAuthError(writer, err)
+		return
+	}
+
+	type parameters struct {
+		CurrentPassword string `json:"current_password"`
+	}
+
+	params := parameters{}
+	err = ExtractBody(&params, req)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	if !config.checkCurrentPassword(writer, req, user, params.CurrentPassword) {
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	_, err = config.DB.EnrollTwoFactor(user.Id, secret)
+	if err != nil {
+		if errors.Is(err, db.TwoFactorEnabledError{}) {
+			RespondWithError(writer, 409, err.Error())
+			return
+		}
+
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	RespondWithJSON(writer, 200, struct {
+		Secret          string `json:"secret"`
+		ProvisioningURI string `json:"provisioning_uri"`
+	}{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(secret, jwtIssuer, user.Email),
+	})
+}
+
+// Activates two-factor with the first code from the authenticator app,
+// returning recovery codes. They are only ever shown here.
+func (config *ApiConfig) PostTwoFactorVerifyHandler(writer http.ResponseWriter, req *http.Request) {
+	userId, err := config.AuthenticateRequest(req)
+	if err != nil {
+		RespondWithAuthError(writer, err)
+		return
+	}
+
+	code, err := extractTwoFactorCode(req)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	recoveryCodes, err := newRecoveryCodes()
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	_, err = config.DB.EnableTwoFactor(userId, code, recoveryCodes)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.NotFoundError{Model: "TwoFactor"}):
+			RespondWithError(writer, 409, "Two-factor enrollment has not been started")
+		case errors.Is(err, db.TwoFactorEnabledError{}):
+			RespondWithError(writer, 409, err.Error())
+		case errors.Is(err, db.InvalidTwoFactorCodeError{}):
+			RespondWithError(writer, 400, err.Error())
+		default:
+			RespondWithError(writer, 500, err.Error())
+		}
+		return
+	}
+
+	config.audit(req, userId, AuditTwoFactorEnable, AuditTargetUser, userId, "")
+
+	RespondWithJSON(writer, 200, struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+// Turns two-factor off, which requires the current password along with a
+// current TOTP or recovery code
+func (config *ApiConfig) DeleteTwoFactorHandler(writer http.ResponseWriter, req *http.Request) {
+	user, _, err := config.authenticate(req)
+	if err != nil {
+		RespondWithAuthError(writer, err)
+		return
+	}
+
+	type parameters struct {
+		Code            string `json:"code"`
+		CurrentPassword string `json:"current_password"`
+	}
+
+	params := parameters{}
+	err = ExtractBody(&params, req)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	if len(params.Code) == 0 {
+		RespondWithError(writer, 400, "Missing code")
+		return
+	}
+
+	if !config.checkCurrentPassword(writer, req, user, params.CurrentPassword) {
+		return
+	}
+
+	// Codes are only six digits, so guessing them is throttled like passwords
+	ip := clientIP(req)
+	if wait := config.LoginThrottle.Reserve(user.Email, ip); wait > 0 {
+		respondWithLockout(writer, wait)
+		return
+	}
+
+	err = config.DB.VerifyTwoFactorCode(user.Id, params.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.NotFoundError{Model: "TwoFactor"}):
+			config.LoginThrottle.Release(user.Email, ip)
+			RespondWithError(writer, 409, "Two-factor authentication is not enabled")
+		case errors.Is(err, db.InvalidTwoFactorCodeError{}):
+			RespondWithError(writer, 400, err.Error())
+		default:
+			RespondWithError(writer, 500, err.Error())
+		}
+		return
+	}
+	config.LoginThrottle.Release(user.Email, ip)
+
+	_, err = config.DB.DisableTwoFactor(user.Id)
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	config.audit(req, user.Id, AuditTwoFactorDisable, AuditTargetUser, user.Id, "")
+
+	writer.WriteHeader(204)
+}
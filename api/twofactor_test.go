@@ -0,0 +1,255 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PFrek/chirpy/db"
+	"github.com/PFrek/chirpy/totp"
+)
+
+// Creates a user with two-factor enabled, returning its secret
+func newTwoFactorUser(t *testing.T, config *ApiConfig, email string) (db.User, string) {
+	t.Helper()
+
+	user, _, _ := newTestSession(t, config, email)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = config.DB.EnrollTwoFactor(user.Id, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Enabling uses up the step before the current one, so the login codes
+	// below are still accepted
+	code, err := totp.Code(secret, time.Now().Add(-totp.Period))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err = config.DB.EnableTwoFactor(user.Id, code, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user, secret
+}
+
+func newChallenge(t *testing.T, config *ApiConfig, user db.User) string {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	config.respondWithTwoFactorChallenge(recorder, user)
+	if recorder.Code != 200 {
+		t.Fatalf("challenge status = %d, want 200", recorder.Code)
+	}
+
+	body := struct {
+		ChallengeToken string `json:"challenge_token"`
+	}{}
+	json.NewDecoder(recorder.Body).Decode(&body)
+	return body.ChallengeToken
+}
+
+func postTwoFactorLogin(config *ApiConfig, challenge string, code string) int {
+	body, _ := json.Marshal(map[string]string{"challenge_token": challenge, "code": code})
+	req := httptest.NewRequest("POST", "/api/login/2fa", strings.NewReader(string(body)))
+
+	recorder := httptest.NewRecorder()
+	config.PostLoginTwoFactorHandler(recorder, req)
+	return recorder.Code
+}
+
+func TestTwoFactorChallenge(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		// Runs between issuing the challenge and completing the login with
+		// a valid code
+		change func(t *testing.T, config *ApiConfig, user db.User, challenge string, secret string)
+		want   int
+	}{
+		{
+			name:   "valid",
+			change: func(t *testing.T, config *ApiConfig, user db.User, challenge string, secret string) {},
+			want:   200,
+		},
+		{
+			name: "already used",
+			change: func(t *testing.T, config *ApiConfig, user db.User, challenge string, secret string) {
+				code, err := totp.Code(secret, now)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if status := postTwoFactorLogin(config, challenge, code); status != 200 {
+					t.Fatalf("first login status = %d, want 200", status)
+				}
+			},
+			want: 401,
+		},
+		{
+			// The challenge is used up by any attempt
+			name: "after a wrong code",
+			change: func(t *testing.T, config *ApiConfig, user db.User, challenge string, secret string) {
+				if status := postTwoFactorLogin(config, challenge, "000000"); status != 401 {
+					t.Fatalf("wrong code status = %d, want 401", status)
+				}
+			},
+			want: 401,
+		},
+		{
+			name: "after logging out everywhere",
+			change: func(t *testing.T, config *ApiConfig, user db.User, challenge string, secret string) {
+				_, err := config.DB.RevokeAllSessions(user.Id)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: 401,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := newTestConfig(t)
+			user, secret := newTwoFactorUser(t, config, "user@example.com")
+			challenge := newChallenge(t, config, user)
+
+			test.change(t, config, user, challenge, secret)
+
+			code, err := totp.Code(secret, now.Add(totp.Period))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status := postTwoFactorLogin(config, challenge, code); status != test.want {
+				t.Errorf("login status = %d, want %d", status, test.want)
+			}
+		})
+	}
+}
+
+func TestTwoFactorChangesNeedPassword(t *testing.T) {
+	tests := []struct {
+		name            string
+		currentPassword string
+		// Whether to send a valid code when disabling
+		validCode bool
+		want      int
+	}{
+		{"without the current password", "", true, 400},
+		{"with a wrong password", "wrong-password", true, 403},
+		{"with a wrong code", "Secr3t-pass!", false, 400},
+		{"with the current password", "Secr3t-pass!", true, 204},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := newTestConfig(t)
+			user, secret := newTwoFactorUser(t, config, "user@example.com")
+			hashed, err := config.Passwords.Hash("Secr3t-pass!")
+			if err != nil {
+				t.Fatal(err)
+			}
+			user, err = config.DB.SetUserPassword(user.Id, hashed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := config.generateJWTToken(user, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			code := "000000"
+			if test.validCode {
+				code, err = totp.Code(secret, time.Now().Add(totp.Period))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			body, _ := json.Marshal(map[string]string{"code": code, "current_password": test.currentPassword})
+			req := httptest.NewRequest("DELETE", "/api/users/me/2fa", strings.NewReader(string(body)))
+			req.Header.Set("Authorization", "Bearer "+token)
+			recorder := httptest.NewRecorder()
+			config.DeleteTwoFactorHandler(recorder, req)
+			if recorder.Code != test.want {
+				t.Fatalf("disable status = %d, want %d", recorder.Code, test.want)
+			}
+
+			updated, err := config.DB.GetUserById(user.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if updated.HasTwoFactor() != (test.want != 204) {
+				t.Fatalf("two-factor enabled = %v after status %d", updated.HasTwoFactor(), recorder.Code)
+			}
+			if test.want != 204 {
+				return
+			}
+
+			// Enrolling again needs the password too
+			for _, password := range []string{"", "wrong-password"} {
+				body, _ = json.Marshal(map[string]string{"current_password": password})
+				req = httptest.NewRequest("POST", "/api/users/me/2fa", strings.NewReader(string(body)))
+				req.Header.Set("Authorization", "Bearer "+token)
+				recorder = httptest.NewRecorder()
+				config.PostTwoFactorEnrollHandler(recorder, req)
+				if recorder.Code == 200 {
+					t.Errorf("enrolling with password %q succeeded", password)
+				}
+			}
+
+			body, _ = json.Marshal(map[string]string{"current_password": "Secr3t-pass!"})
+			req = httptest.NewRequest("POST", "/api/users/me/2fa", strings.NewReader(string(body)))
+			req.Header.Set("Authorization", "Bearer "+token)
+			recorder = httptest.NewRecorder()
+			config.PostTwoFactorEnrollHandler(recorder, req)
+			if recorder.Code != 200 {
+				t.Errorf("enroll status = %d, want 200", recorder.Code)
+			}
+		})
+	}
+}
+
+func TestTwoFactorDisableThrottled(t *testing.T) {
+	config := newTestConfig(t)
+	user, _ := newTwoFactorUser(t, config, "user@example.com")
+	hashed, err := config.Passwords.Hash("Secr3t-pass!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err = config.DB.SetUserPassword(user.Id, hashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := config.generateJWTToken(user, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disable := func(password string) int {
+		body, _ := json.Marshal(map[string]string{"code": "000000", "current_password": password})
+		req := httptest.NewRequest("DELETE", "/api/users/me/2fa", strings.NewReader(string(body)))
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		config.DeleteTwoFactorHandler(recorder, req)
+		return recorder.Code
+	}
+
+	// The failure past the allowance starts the lockout
+	for range DefaultLoginThrottleConfig().MaxAccountFailures + 1 {
+		if status := disable("wrong-password"); status != 403 {
+			t.Fatalf("wrong password status = %d, want 403", status)
+		}
+	}
+
+	if status := disable("Secr3t-pass!"); status != 429 {
+		t.Errorf("status once locked out = %d, want 429", status)
+	}
+}
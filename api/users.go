@@ -3,17 +3,57 @@ package api
 import (
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/PFrek/chirpy/db"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/PFrek/chirpy/mailer"
+	"github.com/PFrek/chirpy/passhash"
 )
 
+// Public profile of a user. It must never include the email address.
 type ResponseUser struct {
-	Id          int    `json:"id"`
-	Email       string `json:"email"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Id          int            `json:"id"`
+	Username    string         `json:"username"`
+	DisplayName string         `json:"display_name"`
+	Bio         string         `json:"bio"`
+	Location    string         `json:"location"`
+	Website     string         `json:"website"`
+	Avatar      *db.Attachment `json:"avatar"`
+	IsChirpyRed bool           `json:"is_chirpy_red"`
+	CreatedAt   time.Time      `json:"created_at"`
+}
+
+func newResponseUser(user db.User) ResponseUser {
+	return ResponseUser{
+		Id:          user.Id,
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		Location:    user.Location,
+		Website:     user.Website,
+		Avatar:      user.Avatar,
+		IsChirpyRed: user.IsChirpyRed,
+		CreatedAt:   user.CreatedAt,
+	}
+}
+
+// What users see of their own account
+type AccountResponseUser struct {
+	ResponseUser
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+func newAccountResponseUser(user db.User) AccountResponseUser {
+	return AccountResponseUser{
+		ResponseUser:  newResponseUser(user),
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+	}
 }
 
 func (config *ApiConfig) PostLoginHandler(writer http.ResponseWriter, req *http.Request) {
@@ -29,9 +69,19 @@ func (config *ApiConfig) PostLoginHandler(writer http.ResponseWriter, req *http.
 		return
 	}
 
+	ip := clientIP(req)
+	if wait := config.LoginThrottle.Reserve(params.Email, ip); wait > 0 {
+		respondWithLockout(writer, wait)
+		return
+	}
+
 	user, err := config.DB.GetUserByEmail(params.Email)
 	if err != nil {
 		if errors.Is(err, db.NotFoundError{Model: "User"}) {
+			// Hash anyway so unknown emails take as long as wrong passwords
+			passhash.Verify(params.Password, config.dummyPasswordHash)
+
+			config.audit(req, 0, AuditLoginFailed, AuditTargetUser, 0, "Unknown email")
 			RespondWithError(writer, 401, "Invalid email or password")
 			return
 		}
@@ -40,38 +90,139 @@ func (config *ApiConfig) PostLoginHandler(writer http.ResponseWriter, req *http.
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(params.Password))
+	match, err := passhash.Verify(params.Password, user.Password)
 	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	if !match {
+		config.audit(req, 0, AuditLoginFailed, AuditTargetUser, user.Id, "Wrong password")
 		RespondWithError(writer, 401, "Invalid email or password")
 		return
 	}
+	config.LoginThrottle.Release(params.Email, ip)
 
-	// JWT
-	tokenStr, err := config.generateJWTToken(user.Id)
+	if user.IsSuspended() {
+		RespondWithAuthError(writer, SuspendedError{ExpiresAt: user.Suspension.ExpiresAt})
+		return
+	}
+
+	config.rehashPassword(user, params.Password)
+
+	if user.HasTwoFactor() {
+		config.respondWithTwoFactorChallenge(writer, user)
+		return
+	}
+
+	config.LoginThrottle.RecordSuccess(user.Email)
+	config.respondWithLogin(writer, req, user)
+}
+
+// Responds with the policy violations and returns false if the password
+// isn't acceptable
+func (config *ApiConfig) checkPasswordPolicy(writer http.ResponseWriter, password string, email string) bool {
+	violations, err := config.PasswordPolicy.Check(password, email)
 	if err != nil {
-		RespondWithError(writer, 500, "Failed to create JWT string token")
+		RespondWithError(writer, 500, err.Error())
+		return false
+	}
+
+	if len(violations) == 0 {
+		return true
+	}
+
+	RespondWithJSON(writer, 400, struct {
+		Error      string               `json:"error"`
+		Violations []passhash.Violation `json:"violations"`
+	}{
+		Error:      "Password does not meet the requirements",
+		Violations: violations,
+	})
+	return false
+}
+
+// Confirms a sensitive change with the user's current password, responding
+// and returning false unless it matches. A stolen access token shouldn't
+// allow guessing the password freely, so attempts are throttled like logins.
+func (config *ApiConfig) checkCurrentPassword(writer http.ResponseWriter, req *http.Request, user db.User, password string) bool {
+	if len(password) == 0 {
+		RespondWithError(writer, 400, "Current password is required")
+		return false
+	}
+
+	ip := clientIP(req)
+	if wait := config.LoginThrottle.Reserve(user.Email, ip); wait > 0 {
+		respondWithLockout(writer, wait)
+		return false
+	}
+
+	match, err := passhash.Verify(password, user.Password)
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return false
+	}
+
+	if !match {
+		RespondWithError(writer, 403, "Current password is incorrect")
+		return false
+	}
+	config.LoginThrottle.Release(user.Email, ip)
+
+	return true
+}
+
+// Replaces the stored hash if it was made with outdated settings. The
+// password was just verified, so this is the only chance to do it.
+func (config *ApiConfig) rehashPassword(user db.User, password string) {
+	if !config.Passwords.NeedsRehash(user.Password) {
+		return
+	}
+
+	hashed, err := config.Passwords.Hash(password)
+	if err != nil {
+		log.Printf("Failed to rehash password of user %d: %v\n", user.Id, err)
 		return
 	}
 
+	_, err = config.DB.SetUserPassword(user.Id, hashed)
+	if err != nil {
+		log.Printf("Failed to rehash password of user %d: %v\n", user.Id, err)
+	}
+}
+
+// Starts a session for a user who has proven their identity
+func (config *ApiConfig) respondWithLogin(writer http.ResponseWriter, req *http.Request, user db.User) {
 	// Refresh
-	refresh, err := config.generateRefreshToken(user.Id)
+	refresh, sessionId, err := config.generateRefreshToken(user.Id, req)
 	if err != nil {
 		RespondWithError(writer, 500, fmt.Sprintf("Failed to generate refresh token: %v\n", err))
 		return
 	}
 
+	// JWT
+	tokenStr, err := config.generateJWTToken(user, sessionId)
+	if err != nil {
+		RespondWithError(writer, 500, "Failed to create JWT string token")
+		return
+	}
+
+	config.audit(req, user.Id, AuditLogin, AuditTargetUser, user.Id, "")
+
 	response := struct {
-		Id           int    `json:"id"`
-		Email        string `json:"email"`
-		IsChirpyRed  bool   `json:"is_chirpy_red"`
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
+		Id            int    `json:"id"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		IsChirpyRed   bool   `json:"is_chirpy_red"`
+		Token         string `json:"token"`
+		RefreshToken  string `json:"refresh_token"`
 	}{
-		Id:           user.Id,
-		Email:        user.Email,
-		IsChirpyRed:  user.IsChirpyRed,
-		Token:        tokenStr,
-		RefreshToken: refresh,
+		Id:            user.Id,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		IsChirpyRed:   user.IsChirpyRed,
+		Token:         tokenStr,
+		RefreshToken:  refresh,
 	}
 
 	RespondWithJSON(writer, 200, response)
@@ -84,22 +235,56 @@ func (config *ApiConfig) PostRefreshHandler(writer http.ResponseWriter, req *htt
 		return
 	}
 
-	id, err := config.DB.ValidateRefreshToken(refresh)
+	// Suspended users keep their sessions but can't use them, and the token
+	// isn't rotated so it still works once the suspension is over. Tokens
+	// that don't validate are left to RotateRefreshToken, which detects reuse.
+	userId, err := config.DB.ValidateRefreshToken(refresh)
+	if err == nil {
+		user, err := config.DB.GetUserById(userId)
+		if err == nil && user.IsSuspended() {
+			RespondWithAuthError(writer, SuspendedError{ExpiresAt: user.Suspension.ExpiresAt})
+			return
+		}
+	}
+
+	newRefresh, err := newRefreshTokenString()
+	if err != nil {
+		RespondWithError(writer, 500, fmt.Sprintf("Failed to generate refresh token: %v\n", err))
+		return
+	}
+
+	rotated, err := config.DB.RotateRefreshToken(refresh, newRefresh, clientInfo(req))
+	if err != nil {
+		var reuseErr db.RefreshTokenReuseError
+		if errors.As(err, &reuseErr) {
+			log.Printf("Refresh token reuse for user %d, revoked token family %s", reuseErr.UserId, reuseErr.FamilyId)
+			config.audit(req, 0, AuditTokenReuse, AuditTargetUser, reuseErr.UserId, "Revoked token family "+reuseErr.FamilyId)
+		}
+
+		RespondWithError(writer, 401, "Unauthorized")
+		return
+	}
+
+	user, err := config.DB.GetUserById(rotated.Id)
 	if err != nil {
 		RespondWithError(writer, 401, "Unauthorized")
 		return
 	}
 
-	jwtToken, err := config.generateJWTToken(id)
+	jwtToken, err := config.generateJWTToken(user, rotated.FamilyId)
 	if err != nil {
 		RespondWithError(writer, 500, "Failed to create JWT string token")
 		return
 	}
 
+	config.audit(req, user.Id, AuditTokenRefresh, AuditTargetUser, user.Id, "")
+
 	response := struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}{
-		Token: jwtToken,
+		Token:        jwtToken,
+		RefreshToken: newRefresh,
 	}
 
 	RespondWithJSON(writer, 200, response)
@@ -112,7 +297,7 @@ func (config *ApiConfig) PostRevokeHandler(writer http.ResponseWriter, req *http
 		return
 	}
 
-	_, err = config.DB.ValidateRefreshToken(refresh)
+	userId, err := config.DB.ValidateRefreshToken(refresh)
 	if err != nil {
 		RespondWithError(writer, 401, "Unauthorized")
 		return
@@ -124,6 +309,8 @@ func (config *ApiConfig) PostRevokeHandler(writer http.ResponseWriter, req *http
 		return
 	}
 
+	config.audit(req, userId, AuditTokenRevoke, AuditTargetUser, userId, "")
+
 	writer.WriteHeader(204)
 }
 
@@ -131,6 +318,8 @@ func (config *ApiConfig) PostUsersHandler(writer http.ResponseWriter, req *http.
 	type parameters struct {
 		Password string `json:"password"`
 		Email    string `json:"email"`
+		// Optional, it can be picked later
+		Username string `json:"username"`
 	}
 
 	params := parameters{}
@@ -145,15 +334,33 @@ func (config *ApiConfig) PostUsersHandler(writer http.ResponseWriter, req *http.
 		return
 	}
 
-	hashed, err := bcrypt.GenerateFromPassword([]byte(params.Password), 4)
+	err = mailer.ValidateAddress(params.Email)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	if len(params.Username) > 0 {
+		err = validateUsername(params.Username)
+		if err != nil {
+			RespondWithError(writer, 400, err.Error())
+			return
+		}
+	}
+
+	if !config.checkPasswordPolicy(writer, params.Password, params.Email) {
+		return
+	}
+
+	hashed, err := config.Passwords.Hash(params.Password)
 	if err != nil {
 		RespondWithError(writer, 500, err.Error())
 		return
 	}
 
-	user, err := config.DB.CreateUser(params.Email, string(hashed))
+	user, err := config.DB.CreateUser(params.Email, hashed, params.Username)
 	if err != nil {
-		if errors.Is(err, db.ExistingEmailError{}) {
+		if errors.Is(err, db.ExistingEmailError{}) || errors.Is(err, db.ExistingUsernameError{}) {
 			RespondWithError(writer, 400, err.Error())
 			return
 		}
@@ -161,24 +368,29 @@ func (config *ApiConfig) PostUsersHandler(writer http.ResponseWriter, req *http.
 		return
 	}
 
-	responseUser := ResponseUser{
-		Id:          user.Id,
-		Email:       user.Email,
-		IsChirpyRed: user.IsChirpyRed,
-	}
+	config.trySendVerificationEmail(user)
 
-	RespondWithJSON(writer, 201, responseUser)
+	RespondWithJSON(writer, 201, newAccountResponseUser(user))
 }
 
+// Replaces both the email and the password. Deprecated in favour of
+// PATCH /api/users/me and POST /api/users/me/password, and kept working for
+// older clients with the same checks: the current password is required,
+// every session is logged out and the caller gets a fresh one.
 func (config *ApiConfig) PutUsersHandler(writer http.ResponseWriter, req *http.Request) {
-	id, err := config.AuthenticateRequest(req)
+	writer.Header().Set("Deprecation", "true")
+	writer.Header().Add("Link", `</api/users/me>; rel="successor-version"`)
+
+	user, _, err := config.authenticate(req)
 	if err != nil {
-		RespondWithError(writer, 401, err.Error())
+		RespondWithAuthError(writer, err)
+		return
 	}
 
 	type parameters struct {
-		Password string `json:"password"`
-		Email    string `json:"email"`
+		Password        string `json:"password"`
+		Email           string `json:"email"`
+		CurrentPassword string `json:"current_password"`
 	}
 
 	params := parameters{}
@@ -193,18 +405,36 @@ func (config *ApiConfig) PutUsersHandler(writer http.ResponseWriter, req *http.R
 		return
 	}
 
-	hashed, err := bcrypt.GenerateFromPassword([]byte(params.Password), 4)
+	err = mailer.ValidateAddress(params.Email)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	if !config.checkCurrentPassword(writer, req, user, params.CurrentPassword) {
+		return
+	}
+
+	if !config.checkPasswordPolicy(writer, params.Password, params.Email) {
+		return
+	}
+
+	hashed, err := config.Passwords.Hash(params.Password)
 	if err != nil {
 		RespondWithError(writer, 500, err.Error())
 		return
 	}
 
-	user, err := config.DB.UpdateUser(db.User{
-		Id:       id,
-		Email:    params.Email,
-		Password: string(hashed),
-	})
+	oldEmail := user.Email
+	emailChanged := user.Email != params.Email
+	if emailChanged {
+		user.EmailVerified = false
+	}
+
+	user.Email = params.Email
+	user.Password = hashed
 
+	_, err = config.DB.UpdateUser(user)
 	if err != nil {
 		if errors.Is(err, db.ExistingEmailError{}) {
 			RespondWithError(writer, 400, err.Error())
@@ -214,13 +444,214 @@ func (config *ApiConfig) PutUsersHandler(writer http.ResponseWriter, req *http.R
 		return
 	}
 
-	responseUser := ResponseUser{
-		Id:          user.Id,
-		Email:       user.Email,
-		IsChirpyRed: user.IsChirpyRed,
+	user, err = config.DB.RevokeAllSessions(user.Id)
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	if emailChanged {
+		config.trySendVerificationEmail(user)
+		go config.sendEmailChangedNotice(user, oldEmail)
+		config.audit(req, user.Id, AuditUserUpdate, AuditTargetUser, user.Id, "Changed email")
+	}
+	config.audit(req, user.Id, AuditPasswordChange, AuditTargetUser, user.Id, "")
+
+	refresh, sessionId, err := config.generateRefreshToken(user.Id, req)
+	if err != nil {
+		RespondWithError(writer, 500, fmt.Sprintf("Failed to generate refresh token: %v\n", err))
+		return
+	}
+
+	tokenStr, err := config.generateJWTToken(user, sessionId)
+	if err != nil {
+		RespondWithError(writer, 500, "Failed to create JWT string token")
+		return
+	}
+
+	RespondWithJSON(writer, 200, struct {
+		AccountResponseUser
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}{
+		AccountResponseUser: newAccountResponseUser(user),
+		Token:               tokenStr,
+		RefreshToken:        refresh,
+	})
+}
+
+// Tells the previous address about an email change, so the owner notices
+// if someone else took over the account
+func (config *ApiConfig) sendEmailChangedNotice(user db.User, oldEmail string) {
+	err := config.Mailer.Send(mailer.Message{
+		To:      oldEmail,
+		Subject: "Your Chirpy email address was changed",
+		Body: fmt.Sprintf("The email address of your Chirpy account was changed to %s.\n\n"+
+			"If you didn't do this, reset your password right away and contact support.\n", user.Email),
+	})
+	if err != nil {
+		log.Printf("Failed to send email change notice to user %d: %v\n", user.Id, err)
+	}
+}
+
+// Updates only the fields present in the body. Changing the email needs
+// the current password, and the new address verified again.
+func (config *ApiConfig) PatchUserHandler(writer http.ResponseWriter, req *http.Request) {
+	id, err := config.AuthenticateRequest(req)
+	if err != nil {
+		RespondWithAuthError(writer, err)
+		return
+	}
+
+	type parameters struct {
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+		profileParams
+	}
+
+	params := parameters{}
+	err = ExtractBody(&params, req)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	if params.Password != nil {
+		RespondWithError(writer, 400, "Use POST /api/users/me/password to change the password")
+		return
+	}
+
+	user, err := config.DB.GetUserById(id)
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	changed, err := config.applyProfile(&user, params.profileParams)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	oldEmail := user.Email
+	emailChanged := params.Email != nil && *params.Email != user.Email
+	if emailChanged {
+		err = mailer.ValidateAddress(*params.Email)
+		if err != nil {
+			RespondWithError(writer, 400, err.Error())
+			return
+		}
+
+		// Otherwise a stolen access token could move the account to another
+		// address, then take it over with a password reset
+		if len(params.CurrentPassword) == 0 {
+			RespondWithError(writer, 400, "Current password is required to change the email")
+			return
+		}
+
+		if !config.checkCurrentPassword(writer, req, user, params.CurrentPassword) {
+			return
+		}
+
+		user.Email = *params.Email
+		user.EmailVerified = false
+		changed = append(changed, "email")
+	}
+
+	if len(changed) == 0 {
+		RespondWithJSON(writer, 200, newAccountResponseUser(user))
+		return
+	}
+
+	user, err = config.DB.UpdateUser(user)
+	if err != nil {
+		if errors.Is(err, db.ExistingEmailError{}) || errors.Is(err, db.ExistingUsernameError{}) {
+			RespondWithError(writer, 400, err.Error())
+			return
+		}
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	if emailChanged {
+		config.trySendVerificationEmail(user)
+		go config.sendEmailChangedNotice(user, oldEmail)
+	}
+
+	config.audit(req, id, AuditUserUpdate, AuditTargetUser, id, "Changed "+strings.Join(changed, ", "))
+
+	RespondWithJSON(writer, 200, newAccountResponseUser(user))
+}
+
+// Changes the password after checking the current one. Every session is
+// logged out, and the caller gets a fresh one in the response.
+func (config *ApiConfig) PostChangePasswordHandler(writer http.ResponseWriter, req *http.Request) {
+	user, _, err := config.authenticate(req)
+	if err != nil {
+		RespondWithAuthError(writer, err)
+		return
+	}
+
+	type parameters struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	params := parameters{}
+	err = ExtractBody(&params, req)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	if !config.checkCurrentPassword(writer, req, user, params.CurrentPassword) {
+		return
+	}
+
+	if !config.checkPasswordPolicy(writer, params.NewPassword, user.Email) {
+		return
+	}
+
+	hashed, err := config.Passwords.Hash(params.NewPassword)
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	_, err = config.DB.SetUserPassword(user.Id, hashed)
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	user, err = config.DB.RevokeAllSessions(user.Id)
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	config.audit(req, user.Id, AuditPasswordChange, AuditTargetUser, user.Id, "")
+
+	refresh, sessionId, err := config.generateRefreshToken(user.Id, req)
+	if err != nil {
+		RespondWithError(writer, 500, fmt.Sprintf("Failed to generate refresh token: %v\n", err))
+		return
 	}
 
-	RespondWithJSON(writer, 200, responseUser)
+	tokenStr, err := config.generateJWTToken(user, sessionId)
+	if err != nil {
+		RespondWithError(writer, 500, "Failed to create JWT string token")
+		return
+	}
+
+	RespondWithJSON(writer, 200, struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}{
+		Token:        tokenStr,
+		RefreshToken: refresh,
+	})
 }
 
 func (config *ApiConfig) GetUsersHandler(writer http.ResponseWriter, req *http.Request) {
@@ -232,11 +663,7 @@ func (config *ApiConfig) GetUsersHandler(writer http.ResponseWriter, req *http.R
 
 	responseUsers := []ResponseUser{}
 	for _, user := range users {
-		responseUsers = append(responseUsers, ResponseUser{
-			Id:          user.Id,
-			Email:       user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-		})
+		responseUsers = append(responseUsers, newResponseUser(user))
 	}
 
 	RespondWithJSON(writer, 200, responseUsers)
@@ -260,11 +687,69 @@ func (config *ApiConfig) GetUserHandler(writer http.ResponseWriter, req *http.Re
 		return
 	}
 
-	responseUser := ResponseUser{
-		Id:          user.Id,
-		Email:       user.Email,
-		IsChirpyRed: user.IsChirpyRed,
+	RespondWithJSON(writer, 200, newResponseUser(user))
+}
+
+func (config *ApiConfig) PostFollowHandler(writer http.ResponseWriter, req *http.Request) {
+	followerId, err := config.AuthenticateRequest(req)
+	if err != nil {
+		RespondWithAuthError(writer, err)
+		return
+	}
+
+	followeeId, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		RespondWithError(writer, 400, "Invalid [id] value in path")
+		return
 	}
 
-	RespondWithJSON(writer, 200, responseUser)
+	if followerId == followeeId {
+		RespondWithError(writer, 400, "Users cannot follow themselves")
+		return
+	}
+
+	follower, err := config.DB.GetUserById(followerId)
+	if err != nil {
+		RespondWithError(writer, 401, "Unauthorized")
+		return
+	}
+
+	if !config.requireVerifiedEmail(writer, follower, VerifiedActionFollow) {
+		return
+	}
+
+	err = config.DB.FollowUser(followerId, followeeId)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{Model: "User"}) {
+			RespondWithError(writer, 404, "Not Found")
+			return
+		}
+
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	writer.WriteHeader(204)
+}
+
+func (config *ApiConfig) DeleteFollowHandler(writer http.ResponseWriter, req *http.Request) {
+	followerId, err := config.AuthenticateRequest(req)
+	if err != nil {
+		RespondWithAuthError(writer, err)
+		return
+	}
+
+	followeeId, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		RespondWithError(writer, 400, "Invalid [id] value in path")
+		return
+	}
+
+	err = config.DB.UnfollowUser(followerId, followeeId)
+	if err != nil {
+		RespondWithError(writer, 500, err.Error())
+		return
+	}
+
+	writer.WriteHeader(204)
 }
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PFrek/chirpy/mailer"
+)
+
+// Hands sent messages to the test, since emails go out in the background
+type recordingMailer struct {
+	sent chan mailer.Message
+}
+
+func (mailer recordingMailer) Send(msg mailer.Message) error {
+	mailer.sent <- msg
+	return nil
+}
+
+func TestPatchUserEmail(t *testing.T) {
+	tests := []struct {
+		name            string
+		currentPassword string
+		want            int
+	}{
+		{"without the current password", "", 400},
+		{"with a wrong password", "wrong-password", 403},
+		{"with the current password", "Secr3t-pass!", 200},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := newTestConfig(t)
+			sent := make(chan mailer.Message, 2)
+			config.Mailer = recordingMailer{sent: sent}
+
+			user, token, _ := newTestSession(t, config, "old@example.com")
+			hashed, err := config.Passwords.Hash("Secr3t-pass!")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = config.DB.SetUserPassword(user.Id, hashed)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, _ := json.Marshal(map[string]string{"email": "new@example.com", "current_password": test.currentPassword})
+			req := httptest.NewRequest("PATCH", "/api/users/me", strings.NewReader(string(body)))
+			req.Header.Set("Authorization", "Bearer "+token)
+			recorder := httptest.NewRecorder()
+			config.PatchUserHandler(recorder, req)
+
+			if recorder.Code != test.want {
+				t.Fatalf("status = %d, want %d", recorder.Code, test.want)
+			}
+
+			updated, err := config.DB.GetUserById(user.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.want != 200 {
+				if updated.Email != "old@example.com" {
+					t.Errorf("email changed to %s", updated.Email)
+				}
+				return
+			}
+
+			if updated.Email != "new@example.com" || updated.EmailVerified {
+				t.Errorf("user has email %s, verified %v, want an unverified new@example.com", updated.Email, updated.EmailVerified)
+			}
+
+			// The new address gets a verification link, the old one a notice
+			recipients := map[string]bool{}
+			for range 2 {
+				select {
+				case msg := <-sent:
+					recipients[msg.To] = true
+				case <-time.After(5 * time.Second):
+					t.Fatalf("only emailed %v", recipients)
+				}
+			}
+			if !recipients["old@example.com"] || !recipients["new@example.com"] {
+				t.Errorf("emailed %v, want old@example.com and new@example.com", recipients)
+			}
+		})
+	}
+}
+
+func TestPutUsers(t *testing.T) {
+	tests := []struct {
+		name            string
+		authorized      bool
+		currentPassword string
+		want            int
+	}{
+		{"without a token", false, "Secr3t-pass!", 401},
+		{"without the current password", true, "", 400},
+		{"with a wrong password", true, "wrong-password", 403},
+		{"with the current password", true, "Secr3t-pass!", 200},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := newTestConfig(t)
+			sent := make(chan mailer.Message, 2)
+			config.Mailer = recordingMailer{sent: sent}
+
+			user, token, _ := newTestSession(t, config, "old@example.com")
+			hashed, err := config.Passwords.Hash("Secr3t-pass!")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = config.DB.SetUserPassword(user.Id, hashed)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, _ := json.Marshal(map[string]string{
+				"email":            "new@example.com",
+				"password":         "N3w-passw0rd-ok!",
+				"current_password": test.currentPassword,
+			})
+			req := httptest.NewRequest("PUT", "/api/users", strings.NewReader(string(body)))
+			if test.authorized {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			recorder := httptest.NewRecorder()
+			config.PutUsersHandler(recorder, req)
+
+			if recorder.Code != test.want {
+				t.Fatalf("status = %d, want %d", recorder.Code, test.want)
+			}
+			if recorder.Header().Get("Deprecation") != "true" {
+				t.Error("response isn't marked as deprecated")
+			}
+
+			updated, err := config.DB.GetUserById(user.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.want != 200 {
+				if updated.Email != "old@example.com" || updated.Password != hashed {
+					t.Errorf("user changed to %s", updated.Email)
+				}
+				return
+			}
+
+			if updated.Email != "new@example.com" || updated.EmailVerified {
+				t.Errorf("user has email %s, verified %v, want an unverified new@example.com", updated.Email, updated.EmailVerified)
+			}
+			if updated.Password == hashed {
+				t.Error("password wasn't changed")
+			}
+
+			// Other sessions are logged out, the caller gets a new one
+			if status := serve(config.GetSessionsHandler, "GET", "/api/sessions", token).Code; status != 401 {
+				t.Errorf("old token status = %d, want 401", status)
+			}
+			response := struct {
+				Token string `json:"token"`
+			}{}
+			json.NewDecoder(recorder.Body).Decode(&response)
+			if status := serve(config.GetSessionsHandler, "GET", "/api/sessions", response.Token).Code; status != 200 {
+				t.Errorf("new token status = %d, want 200", status)
+			}
+
+			recipients := map[string]bool{}
+			for range 2 {
+				select {
+				case msg := <-sent:
+					recipients[msg.To] = true
+				case <-time.After(5 * time.Second):
+					t.Fatalf("only emailed %v", recipients)
+				}
+			}
+			if !recipients["old@example.com"] || !recipients["new@example.com"] {
+				t.Errorf("emailed %v, want old@example.com and new@example.com", recipients)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PFrek/chirpy/db"
+	"github.com/PFrek/chirpy/keyring"
+	"github.com/PFrek/chirpy/mailer"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// Audience of verification tokens, so they can't be used as access tokens
+	emailVerificationAudience = "chirpy-verify-email"
+	emailVerificationLifetime = 24 * time.Hour
+)
+
+// Actions unverified users can be barred from
+const (
+	VerifiedActionChirp      = "chirp"
+	VerifiedActionAttachment = "attachment"
+	VerifiedActionFollow     = "follow"
+	VerifiedActionReport     = "report"
+)
+
+var verifiedActions = []string{
+	VerifiedActionChirp,
+	VerifiedActionAttachment,
+	VerifiedActionFollow,
+	VerifiedActionReport,
+}
+
+type VerificationConfig struct {
+	// Actions that need a verified email
+	RequiredFor []string
+	// Time a user has to wait between asking for verification emails
+	ResendCooldown time.Duration
+}
+
+func DefaultVerificationConfig() VerificationConfig {
+	return VerificationConfig{
+		RequiredFor:    []string{VerifiedActionChirp, VerifiedActionAttachment},
+		ResendCooldown: time.Minute,
+	}
+}
+
+// Parses a comma separated list of actions, where an empty list restricts
+// nothing
+func ParseVerifiedActions(list string) ([]string, error) {
+	actions := []string{}
+	for _, action := range strings.Split(list, ",") {
+		action = strings.TrimSpace(action)
+		if len(action) == 0 {
+			continue
+		}
+
+		if !slices.Contains(verifiedActions, action) {
+			return nil, fmt.Errorf("Unknown action %s, must be one of: %s", action, strings.Join(verifiedActions, ", "))
+		}
+		actions = append(actions, action)
+	}
+
+	return actions, nil
+}
+
+// Responds with 403 and returns false if the action needs a verified email
+// the user doesn't have
+func (config *ApiConfig) requireVerifiedEmail(writer http.ResponseWriter, user db.User, action string) bool {
+	if user.EmailVerified || !slices.Contains(config.Verification.RequiredFor, action) {
+		return true
+	}
+
+	RespondWithJSON(writer, 403, struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}{
+		Error: "Verify your email address first",
+		Code:  "email_unverified",
+	})
+	return false
+}
+
+type emailVerificationClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// Emails the user a signed link to verify their address. The token id is
+// also stored so the link only works once.
+func (config *ApiConfig) sendVerificationEmail(user db.User) error {
+	data := make([]byte, 16)
+	_, err := rand.Read(data)
+	if err != nil {
+		return err
+	}
+	tokenId := hex.EncodeToString(data)
+
+	now := time.Now().UTC()
+	claims := &emailVerificationClaims{
+		Email: user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    jwtIssuer,
+			Audience:  jwt.ClaimStrings{emailVerificationAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(emailVerificationLifetime)),
+			Subject:   fmt.Sprint(user.Id),
+			ID:        tokenId,
+		},
+	}
+
+	token, err := config.Keys.Sign(claims)
+	if err != nil {
+		return err
+	}
+
+	err = config.DB.CreateOneTimeToken(tokenId, db.TokenPurposeVerifyEmail, user.Id, user.Email, emailVerificationLifetime)
+	if err != nil {
+		return err
+	}
+
+	link := config.BaseURL + "/app/verify-email.html?token=" + url.QueryEscape(token)
+	return config.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Confirm this is your email address by opening the link below:\n\n%s\n\n"+
+			"The link expires in %d hours. If you didn't sign up for Chirpy, ignore this email.\n",
+			link, int(emailVerificationLifetime.Hours())),
+	})
+}
+
+// Sends the verification email in the background after a signup or email
+// change. Failing to send shouldn't undo the change, since the user can ask
+// for another one.
+func (config *ApiConfig) trySendVerificationEmail(user db.User) {
+	go func() {
+		err := config.sendVerificationEmail(user)
+		if err != nil {
+			log.Printf("Failed to send verification email to user %d: %v\n", user.Id, err)
+		}
+	}()
+}
+
+func (config *ApiConfig) PostVerifyEmailHandler(writer http.ResponseWriter, req *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	params := parameters{}
+	err := ExtractBody(&params, req)
+	if err != nil {
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	claims := &emailVerificationClaims{}
+	_, err = jwt.ParseWithClaims(params.Token, claims, config.Keys.Keyfunc,
+		jwt.WithValidMethods([]string{keyring.AlgorithmEdDSA, keyring.AlgorithmRS256}),
+		jwt.WithIssuer(jwtIssuer),
+		jwt.WithAudience(emailVerificationAudience),
+		jwt.WithLeeway(config.JWT.Leeway),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		RespondWithError(writer, 400, "Invalid or expired verification token")
+		return
+	}
+
+	userId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		RespondWithError(writer, 400, "Invalid or expired verification token")
+		return
+	}
+
+	token, err := config.DB.ConsumeOneTimeToken(claims.ID, db.TokenPurposeVerifyEmail)
+	if err != nil || token.UserId != userId || token.Email != claims.Email {
+		RespondWithError(writer, 400, "Invalid or expired verification token")
+		return
+	}
+
+	user, err := config.DB.VerifyUserEmail(userId, token.Email)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{Model: "User"}) {
+			RespondWithError(writer, 400, "Invalid or expired verification token")
+			return
+		}
+
+		RespondWithError(writer, 400, err.Error())
+		return
+	}
+
+	config.audit(req, user.Id, AuditEmailVerify, AuditTargetUser, user.Id, user.Email)
+
+	RespondWithJSON(writer, 200, struct {
+		Id            int    `json:"id"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}{
+		Id:            user.Id,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+	})
+}
+
+func (config *ApiConfig) PostResendVerificationHandler(writer http.ResponseWriter, req *http.Request) {
+	user, _, err := config.authenticate(req)
+	if err != nil {
+		RespondWithAuthError(writer, err)
+		return
+	}
+
+	if user.EmailVerified {
+		RespondWithError(writer, 409, "Email address is already verified")
+		return
+	}
+
+	if wait := config.VerificationResends.Reserve(fmt.Sprint(user.Id)); wait > 0 {
+		respondWithRetryAfter(writer, wait, "A verification email was sent recently, try again later")
+		return
+	}
+
+	err = config.sendVerificationEmail(user)
+	if err != nil {
+		RespondWithError(writer, 500, fmt.Sprintf("Failed to send verification email: %v", err))
+		return
+	}
+
+	writer.WriteHeader(202)
+}
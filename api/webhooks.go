@@ -41,7 +41,10 @@ func (config *ApiConfig) PolkaWebhookHandler(writer http.ResponseWriter, req *ht
 		}
 
 		RespondWithError(writer, 500, err.Error())
+		return
 	}
 
+	config.audit(req, 0, AuditUserUpgrade, AuditTargetUser, params.Data.UserId, "Polka upgrade")
+
 	writer.WriteHeader(204)
 }
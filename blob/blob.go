@@ -0,0 +1,90 @@
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+type Store struct {
+	root string
+}
+
+func NewStore(root string) (*Store, error) {
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create blob store: %v", err)
+	}
+
+	return &Store{root: root}, nil
+}
+
+func IsValidHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// Blobs are sharded by the first two characters of their hash to keep
+// directories small
+func (store *Store) Path(hash string) string {
+	return filepath.Join(store.root, hash[:2], hash)
+}
+
+// Stores the data under its SHA-256 hash, returning the hash. Identical
+// contents are only stored once.
+func (store *Store) Put(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	path := store.Path(hash)
+
+	_, err := os.Stat(path)
+	if err == nil {
+		return hash, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("Blob: Failed to stat blob: %v", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return "", fmt.Errorf("Blob: Failed to create directory: %v", err)
+	}
+
+	// Write to a temporary file first so readers never see partial blobs
+	tmp, err := os.CreateTemp(filepath.Dir(path), hash+".tmp*")
+	if err != nil {
+		return "", fmt.Errorf("Blob: Failed to create file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("Blob: Failed to write file: %v", err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return "", fmt.Errorf("Blob: Failed to write file: %v", err)
+	}
+
+	return hash, nil
+}
+
+func (store *Store) Exists(hash string) bool {
+	if !IsValidHash(hash) {
+		return false
+	}
+
+	_, err := os.Stat(store.Path(hash))
+	return err == nil
+}
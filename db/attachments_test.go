@@ -0,0 +1,102 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetBlobAccess(t *testing.T) {
+	db := newTestDB(t)
+
+	users := map[string]User{}
+	for _, name := range []string{"author", "follower", "stranger"} {
+		user, err := db.CreateUser(name+"@example.com", "unused", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[name] = user
+	}
+	author := users["author"].Id
+
+	err := db.FollowUser(users["follower"].Id, author)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each blob is only referenced the way its name says
+	attach := func(hash string) Attachment {
+		attachment, err := db.CreateAttachment(Attachment{
+			OwnerId:     author,
+			Hash:        hash,
+			ContentType: "image/png",
+			Variants:    []AttachmentVariant{{Name: "thumbnail", Hash: hash + "-thumb", ContentType: "image/jpeg"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return attachment
+	}
+	post := func(chirp Chirp) {
+		_, err := db.CreateChirpChecked(chirp, chirp.CreatedAt, func(recent []Chirp, chirp *Chirp) error { return nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	post(Chirp{AuthorId: author, Visibility: VisibilityPublic, Attachments: []Attachment{attach("public")}})
+	post(Chirp{AuthorId: author, Visibility: VisibilityFollowers, Attachments: []Attachment{attach("followers")}})
+	post(Chirp{AuthorId: author, Visibility: VisibilityPrivate, Attachments: []Attachment{attach("private")}})
+	post(Chirp{AuthorId: author, Visibility: VisibilityPublic, Held: true, Attachments: []Attachment{attach("held")}})
+	attach("unattached")
+
+	avatar := attach("avatar")
+	user := users["stranger"]
+	user.Avatar = &avatar
+	_, err = db.UpdateUser(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		hash        string
+		viewer      string
+		wantVisible bool
+		wantPublic  bool
+	}{
+		{"public", "", true, true},
+		{"public-thumb", "", true, true},
+		{"followers", "", false, false},
+		{"followers", "stranger", false, false},
+		{"followers", "follower", true, false},
+		{"followers-thumb", "author", true, false},
+		{"private", "follower", false, false},
+		{"private", "author", true, false},
+		{"held", "", false, false},
+		{"held", "author", true, false},
+		{"unattached", "stranger", false, false},
+		{"unattached", "author", true, false},
+		{"avatar", "", true, true},
+		{"unknown", "author", false, false},
+	}
+
+	for _, test := range tests {
+		viewerId := users[test.viewer].Id
+		access, err := db.GetBlobAccess(test.hash, viewerId)
+		if err != nil {
+			t.Fatalf("GetBlobAccess() error = %v", err)
+		}
+
+		if access.Visible != test.wantVisible || access.Public != test.wantPublic {
+			t.Errorf("GetBlobAccess(%s) for %q = visible %v, public %v, want %v, %v",
+				test.hash, test.viewer, access.Visible, access.Public, test.wantVisible, test.wantPublic)
+		}
+
+		wantType := "image/png"
+		if strings.HasSuffix(test.hash, "-thumb") {
+			wantType = "image/jpeg"
+		}
+		if access.Visible && access.ContentType != wantType {
+			t.Errorf("GetBlobAccess(%s) content type = %s, want %s", test.hash, access.ContentType, wantType)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Recorded in the db once the audit log is chained with an HMAC
+const auditChainHMAC = "hmac-sha256"
+
+type AuditEntry struct {
+	Id int `json:"id"`
+	// 0 when the action wasn't performed by a known user, e.g. failed logins
+	// or webhooks
+	ActorId    int       `json:"actor_id"`
+	Action     string    `json:"action"`
+	TargetType string    `json:"target_type"`
+	TargetId   int       `json:"target_id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Details    string    `json:"details"`
+	CreatedAt  time.Time `json:"created_at"`
+	// Hash of the previous entry, chaining every entry to the ones before it
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// Computes the HMAC of every field except Hash itself, so the chain can't
+// be rebuilt after tampering without the key
+func (entry AuditEntry) computeHash(key []byte) string {
+	entry.Hash = ""
+	data, _ := json.Marshal(entry)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Unkeyed hash of entries written before the chain used an HMAC
+func (entry AuditEntry) legacyHash() string {
+	entry.Hash = ""
+	data, _ := json.Marshal(entry)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Last entry of the log, kept in a file of its own so removing entries from
+// the end of the log can be detected
+type auditHead struct {
+	Id   int    `json:"id"`
+	Hash string `json:"hash"`
+}
+
+// Reads the key from the file, creating it with a random key if it doesn't
+// exist
+func LoadAuditKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return data, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("Unable to read audit key: %v", err)
+	}
+
+	key := make([]byte, 32)
+	_, err = rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data = []byte(hex.EncodeToString(key))
+	err = os.WriteFile(path, data, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to write audit key: %v", err)
+	}
+
+	return data, nil
+}
+
+// Sets the key chaining the audit log and the file holding its head. Must
+// be called before the log is used. A log written before it was keyed is
+// re-chained with the key, as long as its unkeyed chain is intact.
+func (db *DB) SetAuditSigning(key []byte, headPath string) error {
+	if len(key) == 0 {
+		return errors.New("Audit key must not be empty")
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.auditKey = key
+	db.auditHeadPath = headPath
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+
+	if dbStruct.AuditChain == auditChainHMAC {
+		return nil
+	}
+
+	prevHash := ""
+	for i, entry := range dbStruct.AuditLog {
+		if entry.Id != i+1 || entry.PrevHash != prevHash || entry.Hash != entry.legacyHash() {
+			return fmt.Errorf("Unable to key the audit log: entry %d doesn't match the chain", i+1)
+		}
+		prevHash = entry.Hash
+	}
+
+	prevHash = ""
+	for i := range dbStruct.AuditLog {
+		dbStruct.AuditLog[i].PrevHash = prevHash
+		dbStruct.AuditLog[i].Hash = dbStruct.AuditLog[i].computeHash(key)
+		prevHash = dbStruct.AuditLog[i].Hash
+	}
+	dbStruct.AuditChain = auditChainHMAC
+
+	err = db.writeDB(*dbStruct)
+	if err != nil {
+		return err
+	}
+
+	return db.writeAuditHead(dbStruct.AuditLog)
+}
+
+func (db *DB) writeAuditHead(log []AuditEntry) error {
+	head := auditHead{}
+	if len(log) > 0 {
+		head.Id = log[len(log)-1].Id
+		head.Hash = log[len(log)-1].Hash
+	}
+
+	data, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(db.auditHeadPath, data, 0600)
+	if err != nil {
+		return fmt.Errorf("Unable to write audit head: %v", err)
+	}
+
+	return nil
+}
+
+func (db *DB) readAuditHead() (auditHead, error) {
+	data, err := os.ReadFile(db.auditHeadPath)
+	if err != nil {
+		return auditHead{}, err
+	}
+
+	head := auditHead{}
+	err = json.Unmarshal(data, &head)
+	return head, err
+}
+
+type AuditFilter struct {
+	ActorId    *int
+	Action     *string
+	TargetType *string
+	TargetId   *int
+	Since      *time.Time
+	Until      *time.Time
+}
+
+func (filters AuditFilter) test(entry AuditEntry) bool {
+	switch {
+	case filters.ActorId != nil && entry.ActorId != *filters.ActorId:
+		return false
+	case filters.Action != nil && entry.Action != *filters.Action:
+		return false
+	case filters.TargetType != nil && entry.TargetType != *filters.TargetType:
+		return false
+	case filters.TargetId != nil && entry.TargetId != *filters.TargetId:
+		return false
+	case filters.Since != nil && entry.CreatedAt.Before(*filters.Since):
+		return false
+	case filters.Until != nil && entry.CreatedAt.After(*filters.Until):
+		return false
+	}
+
+	return true
+}
+
+// Appends an entry to the audit log. Entries can never be modified or
+// removed afterwards.
+func (db *DB) AppendAuditEntry(entry AuditEntry) (AuditEntry, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.auditKey == nil {
+		return AuditEntry{}, errors.New("Audit log signing isn't configured")
+	}
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return AuditEntry{}, err
+	}
+
+	entry.Id = len(dbStruct.AuditLog) + 1
+	entry.CreatedAt = time.Now().UTC()
+	if len(dbStruct.AuditLog) > 0 {
+		entry.PrevHash = dbStruct.AuditLog[len(dbStruct.AuditLog)-1].Hash
+	}
+	entry.Hash = entry.computeHash(db.auditKey)
+
+	dbStruct.AuditLog = append(dbStruct.AuditLog, entry)
+
+	err = db.writeDB(*dbStruct)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+
+	// Written after the log, so a crash in between leaves the head behind
+	// rather than looking like a truncation
+	err = db.writeAuditHead(dbStruct.AuditLog)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+
+	return entry, nil
+}
+
+func (db *DB) GetAuditEntries(filters AuditFilter) ([]AuditEntry, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return []AuditEntry{}, err
+	}
+
+	entries := []AuditEntry{}
+	for _, entry := range dbStruct.AuditLog {
+		if filters.test(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// Walks the hash chain, returning the id of the first entry that was
+// altered, removed or reordered, or 0 if the log is intact. Entries removed
+// from the end are reported as the first missing id, which is also the
+// case when the head file is gone.
+func (db *DB) VerifyAuditLog() (int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.auditKey == nil {
+		return 0, errors.New("Audit log signing isn't configured")
+	}
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return 0, err
+	}
+
+	prevHash := ""
+	for i, entry := range dbStruct.AuditLog {
+		if entry.Id != i+1 || entry.PrevHash != prevHash || entry.Hash != entry.computeHash(db.auditKey) {
+			return i + 1, nil
+		}
+
+		prevHash = entry.Hash
+	}
+
+	head, err := db.readAuditHead()
+	if errors.Is(err, os.ErrNotExist) {
+		return len(dbStruct.AuditLog) + 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Entries after the head are fine, since they are keyed like the rest
+	if head.Id > len(dbStruct.AuditLog) {
+		return len(dbStruct.AuditLog) + 1, nil
+	}
+	if head.Id > 0 && dbStruct.AuditLog[head.Id-1].Hash != head.Hash {
+		return head.Id, nil
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,186 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	dir := t.TempDir()
+	db, err := NewDB(filepath.Join(dir, "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.SetAuditSigning([]byte("test-audit-key"), filepath.Join(dir, "audit.head"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// Rewrites the stored db, bypassing every check
+func tamper(t *testing.T, db *DB, change func(dbStruct *DBStructure)) {
+	t.Helper()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	change(dbStruct)
+
+	err = db.writeDB(*dbStruct)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, db *DB)
+		want   int
+	}{
+		{
+			name:   "intact",
+			change: func(t *testing.T, db *DB) {},
+			want:   0,
+		},
+		{
+			name: "altered entry",
+			change: func(t *testing.T, db *DB) {
+				tamper(t, db, func(dbStruct *DBStructure) {
+					dbStruct.AuditLog[1].Details = "nothing to see"
+				})
+			},
+			want: 2,
+		},
+		{
+			name: "altered entry with an unkeyed hash",
+			change: func(t *testing.T, db *DB) {
+				tamper(t, db, func(dbStruct *DBStructure) {
+					dbStruct.AuditLog[1].ActorId = 42
+					dbStruct.AuditLog[1].Hash = dbStruct.AuditLog[1].legacyHash()
+				})
+			},
+			want: 2,
+		},
+		{
+			name: "removed entry",
+			change: func(t *testing.T, db *DB) {
+				tamper(t, db, func(dbStruct *DBStructure) {
+					dbStruct.AuditLog = append(dbStruct.AuditLog[:1], dbStruct.AuditLog[2:]...)
+				})
+			},
+			want: 2,
+		},
+		{
+			name: "reordered entries",
+			change: func(t *testing.T, db *DB) {
+				tamper(t, db, func(dbStruct *DBStructure) {
+					log := dbStruct.AuditLog
+					log[1], log[2] = log[2], log[1]
+				})
+			},
+			want: 2,
+		},
+		{
+			name: "truncated",
+			change: func(t *testing.T, db *DB) {
+				tamper(t, db, func(dbStruct *DBStructure) {
+					dbStruct.AuditLog = dbStruct.AuditLog[:2]
+				})
+			},
+			want: 3,
+		},
+		{
+			name: "missing head",
+			change: func(t *testing.T, db *DB) {
+				err := os.Remove(db.auditHeadPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: 4,
+		},
+		{
+			name: "wrong key",
+			change: func(t *testing.T, db *DB) {
+				db.auditKey = []byte("another-key")
+			},
+			want: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newTestDB(t)
+			for _, action := range []string{"login", "suspend", "chirp_delete"} {
+				_, err := db.AppendAuditEntry(AuditEntry{ActorId: 1, Action: action})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			test.change(t, db)
+
+			got, err := db.VerifyAuditLog()
+			if err != nil {
+				t.Fatalf("VerifyAuditLog() error = %v", err)
+			}
+			if got != test.want {
+				t.Errorf("VerifyAuditLog() = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSetAuditSigningUpgradesLegacyLog(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(filepath.Join(dir, "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A log chained before entries were keyed
+	tamper(t, db, func(dbStruct *DBStructure) {
+		prevHash := ""
+		for i, action := range []string{"login", "suspend"} {
+			entry := AuditEntry{Id: i + 1, Action: action, PrevHash: prevHash}
+			entry.Hash = entry.legacyHash()
+			dbStruct.AuditLog = append(dbStruct.AuditLog, entry)
+			prevHash = entry.Hash
+		}
+	})
+
+	err = db.SetAuditSigning([]byte("test-audit-key"), filepath.Join(dir, "audit.head"))
+	if err != nil {
+		t.Fatalf("SetAuditSigning() error = %v", err)
+	}
+
+	got, err := db.VerifyAuditLog()
+	if err != nil || got != 0 {
+		t.Errorf("VerifyAuditLog() = %d, %v, want 0, nil", got, err)
+	}
+
+	// Tampered legacy logs aren't laundered into a valid keyed chain
+	db, err = NewDB(filepath.Join(dir, "tampered.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tamper(t, db, func(dbStruct *DBStructure) {
+		entry := AuditEntry{Id: 1, Action: "login"}
+		entry.Hash = "forged"
+		dbStruct.AuditLog = append(dbStruct.AuditLog, entry)
+	})
+
+	err = db.SetAuditSigning([]byte("test-audit-key"), filepath.Join(dir, "tampered.head"))
+	if err == nil {
+		t.Error("SetAuditSigning() error = nil for a broken legacy chain")
+	}
+}
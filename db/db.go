@@ -2,6 +2,10 @@ package db
 
 import (
 	"cmp"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,19 +14,71 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/PFrek/chirpy/text"
 )
 
 const DB_PATH = "database.json"
 
+const (
+	VisibilityPublic    = "public"
+	VisibilityFollowers = "followers"
+	VisibilityUnlisted  = "unlisted"
+	VisibilityPrivate   = "private"
+)
+
+func IsValidVisibility(visibility string) bool {
+	switch visibility {
+	case VisibilityPublic, VisibilityFollowers, VisibilityUnlisted, VisibilityPrivate:
+		return true
+	}
+
+	return false
+}
+
 type Chirp struct {
-	Id       int    `json:"id"`
-	Body     string `json:"body"`
-	AuthorId int    `json:"author_id"`
+	Id             int          `json:"id"`
+	Body           string       `json:"body"`
+	AuthorId       int          `json:"author_id"`
+	Visibility     string       `json:"visibility"`
+	ContentWarning string       `json:"content_warning"`
+	Sensitive      bool         `json:"sensitive"`
+	Attachments    []Attachment `json:"attachments"`
+	// Set when the chirp needs review by a moderator
+	Flagged bool `json:"flagged,omitempty"`
+	// Set when the chirp is hidden from everyone but its author until a
+	// moderator reviews it
+	Held      bool      `json:"held,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (chirp Chirp) IsSensitive() bool {
+	return chirp.Sensitive || len(chirp.ContentWarning) > 0
 }
 
 type ChirpFilter struct {
 	AuthorId *int
 	Contains *string
+	// Excludes chirps with a content warning or flagged as sensitive
+	HideSensitive *bool
+	// Id of the user requesting the chirps, 0 for anonymous requests
+	ViewerId int
+}
+
+func (filters ChirpFilter) testSensitive(chirp Chirp) bool {
+	if filters.HideSensitive == nil || !*filters.HideSensitive {
+		return true
+	}
+
+	return !chirp.IsSensitive()
+}
+
+func (filters ChirpFilter) testListed(chirp Chirp) bool {
+	if chirp.Visibility != VisibilityUnlisted {
+		return true
+	}
+
+	return chirp.AuthorId == filters.ViewerId
 }
 
 func (filters ChirpFilter) testAuthorId(id int) bool {
@@ -57,28 +113,205 @@ func (sorter ChirpSorter) sortChirps(chirps []Chirp) {
 	slices.SortFunc(chirps, sorter.sort)
 }
 
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleUser, RoleModerator, RoleAdmin:
+		return true
+	}
+
+	return false
+}
+
+// Returns the rank of the role, higher ranks include the permissions of
+// lower ones
+func RoleRank(role string) int {
+	switch role {
+	case RoleAdmin:
+		return 2
+	case RoleModerator:
+		return 1
+	}
+
+	return 0
+}
+
 type User struct {
+	Id          int          `json:"id"`
+	Email       string       `json:"email"`
+	Password    string       `json:"password"`
+	IsChirpyRed bool         `json:"is_chirpy_red"`
+	Suspension  *Restriction `json:"suspension"`
+	Shadowban   *Restriction `json:"shadowban"`
+	// Whether the user proved they own Email
+	EmailVerified bool `json:"email_verified"`
+	// Unique regardless of case. Empty for users who haven't picked one.
+	Username    string      `json:"username"`
+	DisplayName string      `json:"display_name"`
+	Bio         string      `json:"bio"`
+	Location    string      `json:"location"`
+	Website     string      `json:"website"`
+	Avatar      *Attachment `json:"avatar"`
+	// Empty for users created before roles existed, same as RoleUser
+	Role string `json:"role"`
+	// Bumped to invalidate every access token issued before
+	TokenVersion int `json:"token_version"`
+	// nil until the user starts enrolling in two-factor authentication
+	TwoFactor *TwoFactor `json:"two_factor"`
+	// Zero for users created before this was recorded
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// A moderation measure placed on a user's account
+type Restriction struct {
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+	// nil for restrictions that last until lifted
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (restriction *Restriction) IsActive() bool {
+	if restriction == nil {
+		return false
+	}
+
+	return restriction.ExpiresAt == nil || restriction.ExpiresAt.After(time.Now().UTC())
+}
+
+func (user User) IsSuspended() bool {
+	return user.Suspension.IsActive()
+}
+
+func (user User) IsShadowbanned() bool {
+	return user.Shadowban.IsActive()
+}
+
+type Attachment struct {
 	Id          int    `json:"id"`
-	Email       string `json:"email"`
-	Password    string `json:"password"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	OwnerId     int    `json:"owner_id"`
+	Hash        string `json:"hash"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	URL         string `json:"url"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	// Downscaled copies for clients to pick from, e.g. thumbnails
+	Variants  []AttachmentVariant `json:"variants"`
+	CreatedAt time.Time           `json:"created_at"`
+}
+
+type AttachmentVariant struct {
+	Name        string `json:"name"`
+	Hash        string `json:"hash"`
+	ContentType string `json:"content_type"`
+	URL         string `json:"url"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+}
+
+type ProfanityRule struct {
+	Id     int    `json:"id"`
+	Word   string `json:"word"`
+	Action string `json:"action"`
+}
+
+var defaultProfanityRules = map[int]ProfanityRule{
+	1: {Id: 1, Word: "kerfuffle", Action: text.ActionMask},
+	2: {Id: 2, Word: "sharbert", Action: text.ActionMask},
+	3: {Id: 3, Word: "fornax", Action: text.ActionMask},
 }
 
 type RefreshToken struct {
-	Token     string
+	// SHA-256 digest of the token, the token itself is never stored
+	TokenHash string
 	ExpiresAt time.Time
 	Id        int
+	// Tokens rotated from the same login share a family
+	FamilyId string
+	// Set once the token has been exchanged for a new one
+	RotatedAt *time.Time
+	RevokedAt *time.Time
+	// When the session, i.e. the token family, started
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	// Client that last used the session
+	IP        string
+	UserAgent string
+}
+
+type ClientInfo struct {
+	IP        string
+	UserAgent string
 }
 
+// Version of the db layout, bumped whenever existing data needs migrating
+const currentVersion = 3
+
 type DBStructure struct {
+	Version       int           `json:"version"`
 	Chirps        map[int]Chirp `json:"chirps"`
 	Users         map[int]User  `json:"users"`
 	RefreshTokens map[string]RefreshToken
+	// Maps a follower's id to the ids of the users they follow
+	Follows        map[int][]int         `json:"follows"`
+	Attachments    map[int]Attachment    `json:"attachments"`
+	ProfanityRules map[int]ProfanityRule `json:"profanity_rules"`
+	Reports        map[int]Report        `json:"reports"`
+	AuditLog       []AuditEntry          `json:"audit_log"`
+	// How the audit log is chained, empty for unkeyed SHA-256
+	AuditChain string `json:"audit_chain"`
+	// Keyed by the digest of the token
+	OneTimeTokens map[string]OneTimeToken `json:"one_time_tokens"`
+}
+
+func newDBStructure() DBStructure {
+	dbStruct := DBStructure{Version: currentVersion}
+	dbStruct.ensureMaps()
+	return dbStruct
+}
+
+// Initializes collections missing from databases written by older versions
+func (dbStruct *DBStructure) ensureMaps() {
+	if dbStruct.Chirps == nil {
+		dbStruct.Chirps = make(map[int]Chirp)
+	}
+	if dbStruct.Users == nil {
+		dbStruct.Users = make(map[int]User)
+	}
+	if dbStruct.RefreshTokens == nil {
+		dbStruct.RefreshTokens = make(map[string]RefreshToken)
+	}
+	if dbStruct.Follows == nil {
+		dbStruct.Follows = make(map[int][]int)
+	}
+	if dbStruct.Attachments == nil {
+		dbStruct.Attachments = make(map[int]Attachment)
+	}
+	if dbStruct.Reports == nil {
+		dbStruct.Reports = make(map[int]Report)
+	}
+	if dbStruct.OneTimeTokens == nil {
+		dbStruct.OneTimeTokens = make(map[string]OneTimeToken)
+	}
+	if dbStruct.ProfanityRules == nil {
+		dbStruct.ProfanityRules = make(map[int]ProfanityRule)
+		for id, rule := range defaultProfanityRules {
+			dbStruct.ProfanityRules[id] = rule
+		}
+	}
 }
 
 type DB struct {
 	path string
 	mu   *sync.RWMutex
+	// See SetAuditSigning
+	auditKey      []byte
+	auditHeadPath string
 }
 
 func NewDB(path string) (*DB, error) {
@@ -92,17 +325,73 @@ func NewDB(path string) (*DB, error) {
 		return nil, fmt.Errorf("Unable to create new DB: %v", err)
 	}
 
+	err = db.migrate()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to migrate DB: %v", err)
+	}
+
 	return &db, nil
 }
 
+// Upgrades data written by older versions to the current layout
+func (db *DB) migrate() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+
+	if dbStruct.Version >= currentVersion {
+		return nil
+	}
+
+	// Version 1: refresh tokens are keyed by their digest instead of the
+	// raw token
+	if dbStruct.Version < 1 {
+		hashed := make(map[string]RefreshToken)
+		for token, refreshToken := range dbStruct.RefreshTokens {
+			refreshToken.TokenHash = hashRefreshToken(token)
+			hashed[refreshToken.TokenHash] = refreshToken
+		}
+		dbStruct.RefreshTokens = hashed
+	}
+
+	// Version 2: every refresh token belongs to a family, which identifies
+	// the session
+	if dbStruct.Version < 2 {
+		for key, refreshToken := range dbStruct.RefreshTokens {
+			if len(refreshToken.FamilyId) > 0 {
+				continue
+			}
+
+			refreshToken.FamilyId, err = newFamilyId()
+			if err != nil {
+				return err
+			}
+			dbStruct.RefreshTokens[key] = refreshToken
+		}
+	}
+
+	// Version 3: emails need verifying. Accounts created before then are
+	// trusted rather than suddenly restricted.
+	if dbStruct.Version < 3 {
+		for id, user := range dbStruct.Users {
+			user.EmailVerified = true
+			dbStruct.Users[id] = user
+		}
+	}
+
+	dbStruct.Version = currentVersion
+
+	return db.writeDB(*dbStruct)
+}
+
 func (db *DB) ensureDB() error {
 	_, err := os.ReadFile(db.path)
 	if errors.Is(err, os.ErrNotExist) {
-		dbStruct := DBStructure{
-			Chirps:        make(map[int]Chirp),
-			Users:         make(map[int]User),
-			RefreshTokens: make(map[string]RefreshToken),
-		}
+		dbStruct := newDBStructure()
 
 		json, err := json.Marshal(dbStruct)
 		if err != nil {
@@ -140,6 +429,28 @@ func (db DBStructure) getNextUserId() int {
 	return maxId + 1
 }
 
+func (db DBStructure) getNextAttachmentId() int {
+	maxId := 0
+	for _, attachment := range db.Attachments {
+		if attachment.Id > maxId {
+			maxId = attachment.Id
+		}
+	}
+
+	return maxId + 1
+}
+
+func (db DBStructure) getNextProfanityRuleId() int {
+	maxId := 0
+	for _, rule := range db.ProfanityRules {
+		if rule.Id > maxId {
+			maxId = rule.Id
+		}
+	}
+
+	return maxId + 1
+}
+
 func (db *DB) loadDB() (*DBStructure, error) {
 	err := db.ensureDB()
 	if err != nil {
@@ -159,6 +470,7 @@ func (db *DB) loadDB() (*DBStructure, error) {
 	if db == nil {
 		return nil, errors.New("DB: Failed to unmarshal db: Empty")
 	}
+	dbStruct.ensureMaps()
 
 	return &dbStruct, nil
 }
@@ -184,24 +496,97 @@ func (db *DB) writeDB(dbStruct DBStructure) error {
 
 // REFRESH TOKENS
 
-func (db *DB) CreateRefreshToken(token string, id int) error {
+const refreshTokenLifetime = 60 * 24 * time.Hour
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Looks a token up by its digest. The digest is compared again in constant
+// time so the lookup can't be used as a timing oracle.
+func (db DBStructure) findRefreshToken(token string) (RefreshToken, bool) {
+	tokenHash := hashRefreshToken(token)
+
+	refreshToken, ok := db.RefreshTokens[tokenHash]
+	if !ok {
+		return RefreshToken{}, false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(refreshToken.TokenHash), []byte(tokenHash)) != 1 {
+		return RefreshToken{}, false
+	}
+
+	return refreshToken, true
+}
+
+func newFamilyId() (string, error) {
+	data := make([]byte, 16)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(data), nil
+}
+
+// Stores a refresh token starting a new family, returning the family id.
+// When the user already has maxSessions active sessions the oldest ones are
+// revoked to make room; 0 means no limit.
+func (db *DB) CreateRefreshToken(token string, id int, client ClientInfo, maxSessions int) (string, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	dbStruct, err := db.loadDB()
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	dbStruct.RefreshTokens[token] = RefreshToken{
-		Token:     token,
-		ExpiresAt: time.Now().UTC().Add(60 * 24 * time.Hour),
-		Id:        id,
+	familyId, err := newFamilyId()
+	if err != nil {
+		return "", err
+	}
+
+	if maxSessions > 0 {
+		sessions := dbStruct.activeSessions(id)
+		for len(sessions) >= maxSessions {
+			dbStruct.revokeFamily(sessions[0].FamilyId)
+			sessions = sessions[1:]
+		}
+	}
+
+	now := time.Now().UTC()
+	tokenHash := hashRefreshToken(token)
+	dbStruct.RefreshTokens[tokenHash] = RefreshToken{
+		TokenHash:  tokenHash,
+		ExpiresAt:  now.Add(refreshTokenLifetime),
+		Id:         id,
+		FamilyId:   familyId,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		IP:         client.IP,
+		UserAgent:  client.UserAgent,
 	}
 
 	err = db.writeDB(*dbStruct)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	return familyId, nil
+}
+
+func (refreshToken RefreshToken) validate() error {
+	if refreshToken.RevokedAt != nil {
+		return errors.New("Refresh token revoked")
+	}
+
+	if refreshToken.RotatedAt != nil {
+		return RefreshTokenReuseError{UserId: refreshToken.Id, FamilyId: refreshToken.FamilyId}
+	}
+
+	if refreshToken.ExpiresAt.Before(time.Now().UTC()) {
+		return errors.New("Refresh token expired")
 	}
 
 	return nil
@@ -216,62 +601,169 @@ func (db *DB) ValidateRefreshToken(token string) (int, error) {
 		return 0, err
 	}
 
-	refreshToken, ok := dbStruct.RefreshTokens[token]
+	refreshToken, ok := dbStruct.findRefreshToken(token)
 	if !ok {
 		return 0, errors.New("Refresh token not found")
 	}
 
-	if refreshToken.ExpiresAt.Before(time.Now().UTC()) {
-		return 0, errors.New("Refresh token expired")
+	err = refreshToken.validate()
+	if err != nil {
+		return 0, err
 	}
 
 	return refreshToken.Id, nil
 }
 
-func (db *DB) RevokeRefreshToken(token string) error {
+// Exchanges a refresh token for newToken in the same family. Presenting a
+// token that was already rotated means it leaked, so the whole family is
+// revoked and a RefreshTokenReuseError returned.
+func (db *DB) RotateRefreshToken(token string, newToken string, client ClientInfo) (RefreshToken, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	dbStruct, err := db.loadDB()
 	if err != nil {
-		return err
+		return RefreshToken{}, err
 	}
 
-	delete(dbStruct.RefreshTokens, token)
+	refreshToken, ok := dbStruct.findRefreshToken(token)
+	if !ok {
+		return RefreshToken{}, errors.New("Refresh token not found")
+	}
+
+	err = refreshToken.validate()
+	if err != nil {
+		var reuseErr RefreshTokenReuseError
+		if errors.As(err, &reuseErr) {
+			dbStruct.revokeFamily(refreshToken.FamilyId)
+
+			writeErr := db.writeDB(*dbStruct)
+			if writeErr != nil {
+				return RefreshToken{}, writeErr
+			}
+		}
+
+		return RefreshToken{}, err
+	}
+
+	// Tokens created before families existed start one now
+	if len(refreshToken.FamilyId) == 0 {
+		refreshToken.FamilyId, err = newFamilyId()
+		if err != nil {
+			return RefreshToken{}, err
+		}
+	}
+
+	now := time.Now().UTC()
+	refreshToken.RotatedAt = &now
+	dbStruct.RefreshTokens[refreshToken.TokenHash] = refreshToken
+
+	newTokenHash := hashRefreshToken(newToken)
+	rotated := RefreshToken{
+		TokenHash:  newTokenHash,
+		ExpiresAt:  now.Add(refreshTokenLifetime),
+		Id:         refreshToken.Id,
+		FamilyId:   refreshToken.FamilyId,
+		CreatedAt:  refreshToken.CreatedAt,
+		LastUsedAt: now,
+		IP:         client.IP,
+		UserAgent:  client.UserAgent,
+	}
+	dbStruct.RefreshTokens[newTokenHash] = rotated
 
 	err = db.writeDB(*dbStruct)
 	if err != nil {
-		return err
+		return RefreshToken{}, err
 	}
 
-	return nil
+	return rotated, nil
 }
 
-// CHIRPS
+func (db DBStructure) revokeFamily(familyId string) {
+	if len(familyId) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	for key, refreshToken := range db.RefreshTokens {
+		if refreshToken.FamilyId == familyId && refreshToken.RevokedAt == nil {
+			refreshToken.RevokedAt = &now
+			db.RefreshTokens[key] = refreshToken
+		}
+	}
+}
 
-func (db *DB) CreateChirp(body string, authorId int) (Chirp, error) {
+// Revokes the token along with every token rotated from the same login
+func (db *DB) RevokeRefreshToken(token string) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	dbStruct, err := db.loadDB()
 	if err != nil {
-		return Chirp{}, err
+		return err
 	}
 
-	chirp := Chirp{
-		Id:       dbStruct.getNextChirpId(),
-		Body:     body,
-		AuthorId: authorId,
+	refreshToken, ok := dbStruct.findRefreshToken(token)
+	if !ok {
+		return nil
 	}
 
-	dbStruct.Chirps[chirp.Id] = chirp
+	// Tokens created before families existed
+	if len(refreshToken.FamilyId) == 0 {
+		delete(dbStruct.RefreshTokens, refreshToken.TokenHash)
+	} else {
+		dbStruct.revokeFamily(refreshToken.FamilyId)
+	}
 
 	err = db.writeDB(*dbStruct)
 	if err != nil {
-		return Chirp{}, err
+		return err
 	}
 
-	return chirp, nil
+	return nil
+}
+
+// CHIRPS
+
+func (db DBStructure) isFollowing(followerId, followeeId int) bool {
+	return slices.Contains(db.Follows[followerId], followeeId)
+}
+
+func (db DBStructure) canViewChirp(chirp Chirp, viewerId int) bool {
+	author := db.Users[chirp.AuthorId]
+	if author.IsSuspended() {
+		return false
+	}
+
+	if chirp.AuthorId == viewerId {
+		return true
+	}
+
+	// Shadowbanned users don't notice that nobody else sees their chirps
+	if author.IsShadowbanned() || chirp.Held {
+		return false
+	}
+
+	switch chirp.Visibility {
+	case VisibilityPrivate:
+		return false
+	case VisibilityFollowers:
+		return viewerId != 0 && db.isFollowing(viewerId, chirp.AuthorId)
+	}
+
+	return true
+}
+
+func (db *DB) CanViewChirp(chirp Chirp, viewerId int) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return false, err
+	}
+
+	return dbStruct.canViewChirp(chirp, viewerId), nil
 }
 
 func (db *DB) GetChirps(filters ChirpFilter, sorter ChirpSorter) ([]Chirp, error) {
@@ -287,6 +779,9 @@ func (db *DB) GetChirps(filters ChirpFilter, sorter ChirpSorter) ([]Chirp, error
 	for _, chirp := range dbStruct.Chirps {
 		match := filters.testAuthorId(chirp.AuthorId)
 		match = match && filters.testBodyContains(chirp.Body)
+		match = match && filters.testSensitive(chirp)
+		match = match && filters.testListed(chirp)
+		match = match && dbStruct.canViewChirp(chirp, filters.ViewerId)
 
 		if match {
 			chirps = append(chirps, chirp)
@@ -298,6 +793,53 @@ func (db *DB) GetChirps(filters ChirpFilter, sorter ChirpSorter) ([]Chirp, error
 	return chirps, nil
 }
 
+// Creates the chirp only if check, given the author's chirps since the given
+// time, returns no error. Both happen under the same lock so concurrent posts
+// can't slip past limits based on the author's recent chirps. check may
+// modify the chirp before it is stored, and its error is returned as is.
+func (db *DB) CreateChirpChecked(chirp Chirp, since time.Time, check func(recent []Chirp, chirp *Chirp) error) (Chirp, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	err = check(dbStruct.recentChirpsByAuthor(chirp.AuthorId, since), &chirp)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp.Id = dbStruct.getNextChirpId()
+	chirp.CreatedAt = time.Now().UTC()
+
+	dbStruct.Chirps[chirp.Id] = chirp
+
+	err = db.writeDB(*dbStruct)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return chirp, nil
+}
+
+// Returns the author's chirps created since the given time, oldest first
+func (db DBStructure) recentChirpsByAuthor(authorId int, since time.Time) []Chirp {
+	chirps := []Chirp{}
+	for _, chirp := range db.Chirps {
+		if chirp.AuthorId == authorId && !chirp.CreatedAt.Before(since) {
+			chirps = append(chirps, chirp)
+		}
+	}
+
+	slices.SortFunc(chirps, func(a, b Chirp) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return chirps
+}
+
 func (db *DB) GetChirpById(id int) (Chirp, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
@@ -340,9 +882,285 @@ func (db *DB) DeleteChirp(id int) error {
 	return nil
 }
 
+// ATTACHMENTS
+
+func (db *DB) CreateAttachment(attachment Attachment) (Attachment, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	attachment.Id = dbStruct.getNextAttachmentId()
+	attachment.CreatedAt = time.Now().UTC()
+	dbStruct.Attachments[attachment.Id] = attachment
+
+	err = db.writeDB(*dbStruct)
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	return attachment, nil
+}
+
+func (db *DB) GetAttachmentById(id int) (Attachment, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	attachment, ok := dbStruct.Attachments[id]
+	if !ok {
+		return Attachment{}, NotFoundError{Model: "Attachment"}
+	}
+
+	return attachment, nil
+}
+
+// Returns the content type the attachment stores the blob with, if the blob
+// is its original or one of its variants
+func (attachment Attachment) blobContentType(hash string) (string, bool) {
+	if attachment.Hash == hash {
+		return attachment.ContentType, true
+	}
+
+	for _, variant := range attachment.Variants {
+		if variant.Hash == hash {
+			return variant.ContentType, true
+		}
+	}
+
+	return "", false
+}
+
+// Who may see a stored blob
+type BlobAccess struct {
+	// Whether the viewer may see the blob
+	Visible bool
+	// Whether anyone may, even without logging in
+	Public      bool
+	ContentType string
+}
+
+// Checks whether the viewer may see the blob: it must be an avatar, or
+// attached to a chirp the viewer can see, or uploaded by the viewer.
+// Blobs nothing references aren't visible to anyone.
+func (db *DB) GetBlobAccess(hash string, viewerId int) (BlobAccess, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return BlobAccess{}, err
+	}
+
+	access := BlobAccess{}
+	grant := func(contentType string, public bool) {
+		access.Visible = true
+		access.Public = access.Public || public
+		access.ContentType = contentType
+	}
+
+	for _, user := range dbStruct.Users {
+		if user.Avatar == nil {
+			continue
+		}
+		if contentType, ok := user.Avatar.blobContentType(hash); ok {
+			grant(contentType, true)
+		}
+	}
+
+	for _, chirp := range dbStruct.Chirps {
+		for _, attachment := range chirp.Attachments {
+			contentType, ok := attachment.blobContentType(hash)
+			if ok && dbStruct.canViewChirp(chirp, viewerId) {
+				grant(contentType, dbStruct.canViewChirp(chirp, 0))
+			}
+		}
+	}
+
+	for _, attachment := range dbStruct.Attachments {
+		contentType, ok := attachment.blobContentType(hash)
+		if ok && viewerId != 0 && attachment.OwnerId == viewerId {
+			grant(contentType, false)
+		}
+	}
+
+	return access, nil
+}
+
+// PROFANITY RULES
+
+func (db *DB) GetProfanityRules() ([]ProfanityRule, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return []ProfanityRule{}, err
+	}
+
+	rules := []ProfanityRule{}
+	for _, rule := range dbStruct.ProfanityRules {
+		rules = append(rules, rule)
+	}
+
+	slices.SortFunc(rules, func(a, b ProfanityRule) int {
+		return cmp.Compare(a.Id, b.Id)
+	})
+
+	return rules, nil
+}
+
+func (db *DB) CreateProfanityRule(word string, action string) (ProfanityRule, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return ProfanityRule{}, err
+	}
+
+	for _, rule := range dbStruct.ProfanityRules {
+		if strings.EqualFold(rule.Word, word) {
+			return ProfanityRule{}, ExistingProfanityRuleError{}
+		}
+	}
+
+	rule := ProfanityRule{
+		Id:     dbStruct.getNextProfanityRuleId(),
+		Word:   word,
+		Action: action,
+	}
+
+	dbStruct.ProfanityRules[rule.Id] = rule
+
+	err = db.writeDB(*dbStruct)
+	if err != nil {
+		return ProfanityRule{}, err
+	}
+
+	return rule, nil
+}
+
+func (db *DB) UpdateProfanityRule(rule ProfanityRule) (ProfanityRule, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return ProfanityRule{}, err
+	}
+
+	if _, ok := dbStruct.ProfanityRules[rule.Id]; !ok {
+		return ProfanityRule{}, NotFoundError{Model: "ProfanityRule"}
+	}
+
+	for _, r := range dbStruct.ProfanityRules {
+		if r.Id != rule.Id && strings.EqualFold(r.Word, rule.Word) {
+			return ProfanityRule{}, ExistingProfanityRuleError{}
+		}
+	}
+
+	dbStruct.ProfanityRules[rule.Id] = rule
+
+	err = db.writeDB(*dbStruct)
+	if err != nil {
+		return ProfanityRule{}, err
+	}
+
+	return rule, nil
+}
+
+func (db *DB) DeleteProfanityRule(id int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := dbStruct.ProfanityRules[id]; !ok {
+		return NotFoundError{Model: "ProfanityRule"}
+	}
+
+	delete(dbStruct.ProfanityRules, id)
+
+	return db.writeDB(*dbStruct)
+}
+
+func (db *DB) GetFlaggedChirps() ([]Chirp, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return []Chirp{}, err
+	}
+
+	chirps := []Chirp{}
+	for _, chirp := range dbStruct.Chirps {
+		if chirp.Flagged || chirp.Held {
+			chirps = append(chirps, chirp)
+		}
+	}
+
+	slices.SortFunc(chirps, func(a, b Chirp) int {
+		return cmp.Compare(a.Id, b.Id)
+	})
+
+	return chirps, nil
+}
+
+// FOLLOWS
+
+func (db *DB) FollowUser(followerId, followeeId int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := dbStruct.Users[followeeId]; !ok {
+		return NotFoundError{Model: "User"}
+	}
+
+	if dbStruct.isFollowing(followerId, followeeId) {
+		return nil
+	}
+
+	dbStruct.Follows[followerId] = append(dbStruct.Follows[followerId], followeeId)
+
+	return db.writeDB(*dbStruct)
+}
+
+func (db *DB) UnfollowUser(followerId, followeeId int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+
+	dbStruct.Follows[followerId] = slices.DeleteFunc(dbStruct.Follows[followerId], func(id int) bool {
+		return id == followeeId
+	})
+
+	return db.writeDB(*dbStruct)
+}
+
 // USERS
 
-func (db *DB) CreateUser(email string, password string) (User, error) {
+func (db *DB) CreateUser(email string, password string, username string) (User, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
@@ -355,13 +1173,20 @@ func (db *DB) CreateUser(email string, password string) (User, error) {
 		if user.Email == email {
 			return User{}, ExistingEmailError{}
 		}
+
+		if usernameTaken(user, username) {
+			return User{}, ExistingUsernameError{}
+		}
 	}
 
 	user := User{
 		Id:          dbStruct.getNextUserId(),
 		Email:       email,
 		Password:    password,
+		Username:    username,
 		IsChirpyRed: false,
+		Role:        RoleUser,
+		CreatedAt:   time.Now().UTC(),
 	}
 
 	dbStruct.Users[user.Id] = user
@@ -392,6 +1217,10 @@ func (db *DB) UpdateUser(user User) (User, error) {
 			if u.Email == user.Email {
 				return User{}, ExistingEmailError{}
 			}
+
+			if usernameTaken(u, user.Username) {
+				return User{}, ExistingUsernameError{}
+			}
 		}
 	}
 
@@ -434,6 +1263,78 @@ func (db *DB) UpgradeUser(id int) (User, error) {
 	return existingUser, nil
 }
 
+func (user User) HasRole(role string) bool {
+	return RoleRank(user.Role) >= RoleRank(role)
+}
+
+func (db *DB) SetUserRole(id int, role string) (User, error) {
+	return db.updateUserWith(id, func(user *User) {
+		user.Role = role
+	})
+}
+
+// Marks the email as verified, unless the user changed it since the
+// verification was requested
+func (db *DB) VerifyUserEmail(id int, email string) (User, error) {
+	var err error
+	user, dbErr := db.updateUserWith(id, func(user *User) {
+		if user.Email != email {
+			err = errors.New("Email changed since verification was requested")
+			return
+		}
+
+		user.EmailVerified = true
+	})
+	if dbErr != nil {
+		return User{}, dbErr
+	}
+
+	return user, err
+}
+
+func (db *DB) SetUserPassword(id int, password string) (User, error) {
+	return db.updateUserWith(id, func(user *User) {
+		user.Password = password
+	})
+}
+
+func (db *DB) SetUserSuspension(id int, suspension *Restriction) (User, error) {
+	return db.updateUserWith(id, func(user *User) {
+		user.Suspension = suspension
+	})
+}
+
+func (db *DB) SetUserShadowban(id int, shadowban *Restriction) (User, error) {
+	return db.updateUserWith(id, func(user *User) {
+		user.Shadowban = shadowban
+	})
+}
+
+func (db *DB) updateUserWith(id int, update func(user *User)) (User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	existingUser, ok := dbStruct.Users[id]
+	if !ok {
+		return User{}, NotFoundError{"User"}
+	}
+
+	update(&existingUser)
+	dbStruct.Users[id] = existingUser
+
+	err = db.writeDB(*dbStruct)
+	if err != nil {
+		return User{}, err
+	}
+
+	return existingUser, nil
+}
+
 func (db *DB) GetUsers() ([]User, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
@@ -478,6 +1379,28 @@ func (db *DB) GetUserById(id int) (User, error) {
 	return *user, nil
 }
 
+func usernameTaken(user User, username string) bool {
+	return len(username) > 0 && strings.EqualFold(user.Username, username)
+}
+
+func (db *DB) GetUserByUsername(username string) (User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	for _, user := range dbStruct.Users {
+		if usernameTaken(user, username) {
+			return user, nil
+		}
+	}
+
+	return User{}, NotFoundError{Model: "User"}
+}
+
 func (db *DB) GetUserByEmail(email string) (User, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
@@ -507,6 +1430,63 @@ func (err ExistingEmailError) Error() string {
 	return fmt.Sprintf("Email already in use")
 }
 
+type ExistingUsernameError struct{}
+
+func (err ExistingUsernameError) Error() string {
+	return "Username already in use"
+}
+
+type ExistingProfanityRuleError struct{}
+
+func (err ExistingProfanityRuleError) Error() string {
+	return "Profanity rule already exists for this word"
+}
+
+type ReportTransitionError struct {
+	From string
+	To   string
+}
+
+func (err ReportTransitionError) Error() string {
+	return fmt.Sprintf("Report cannot move from %s to %s", err.From, err.To)
+}
+
+type InvalidReportActionError struct {
+	Action     string
+	TargetType string
+}
+
+func (err InvalidReportActionError) Error() string {
+	return fmt.Sprintf("Action %s doesn't apply to %s reports", err.Action, err.TargetType)
+}
+
+type ReportActionForbiddenError struct{}
+
+func (err ReportActionForbiddenError) Error() string {
+	return "Not allowed to take this action on the reported user"
+}
+
+type RefreshTokenReuseError struct {
+	UserId   int
+	FamilyId string
+}
+
+func (err RefreshTokenReuseError) Error() string {
+	return "Refresh token reused after rotation"
+}
+
+type TwoFactorEnabledError struct{}
+
+func (err TwoFactorEnabledError) Error() string {
+	return "Two-factor authentication is already enabled"
+}
+
+type InvalidTwoFactorCodeError struct{}
+
+func (err InvalidTwoFactorCodeError) Error() string {
+	return "Invalid two-factor code"
+}
+
 type NotFoundError struct {
 	Model string
 }
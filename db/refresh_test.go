@@ -0,0 +1,156 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRotateRefreshToken(t *testing.T) {
+	client := ClientInfo{IP: "127.0.0.1", UserAgent: "test"}
+
+	tests := []struct {
+		name string
+		// Runs against a db holding the token "first" of user 1, returning
+		// the token to rotate
+		setup func(t *testing.T, db *DB) string
+		// Checked against the error of rotating the token
+		wantErr   bool
+		wantReuse bool
+	}{
+		{
+			name:  "valid",
+			setup: func(t *testing.T, db *DB) string { return "first" },
+		},
+		{
+			name:    "unknown",
+			setup:   func(t *testing.T, db *DB) string { return "unknown" },
+			wantErr: true,
+		},
+		{
+			name: "revoked",
+			setup: func(t *testing.T, db *DB) string {
+				err := db.RevokeRefreshToken("first")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return "first"
+			},
+			wantErr: true,
+		},
+		{
+			name: "expired",
+			setup: func(t *testing.T, db *DB) string {
+				tamper(t, db, func(dbStruct *DBStructure) {
+					token := dbStruct.RefreshTokens[hashRefreshToken("first")]
+					token.ExpiresAt = time.Now().UTC().Add(-time.Minute)
+					dbStruct.RefreshTokens[token.TokenHash] = token
+				})
+				return "first"
+			},
+			wantErr: true,
+		},
+		{
+			name: "already rotated",
+			setup: func(t *testing.T, db *DB) string {
+				_, err := db.RotateRefreshToken("first", "second", client)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return "first"
+			},
+			wantErr:   true,
+			wantReuse: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newTestDB(t)
+			familyId, err := db.CreateRefreshToken("first", 1, client, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token := test.setup(t, db)
+
+			rotated, err := db.RotateRefreshToken(token, "rotated", client)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("RotateRefreshToken() error = %v, want error %v", err, test.wantErr)
+			}
+
+			var reuseErr RefreshTokenReuseError
+			if errors.As(err, &reuseErr) != test.wantReuse {
+				t.Fatalf("RotateRefreshToken() error = %v, want reuse %v", err, test.wantReuse)
+			}
+
+			if test.wantErr {
+				if _, err := db.ValidateRefreshToken("rotated"); err == nil {
+					t.Error("failed rotation left a usable token")
+				}
+				return
+			}
+
+			if rotated.Id != 1 || rotated.FamilyId != familyId {
+				t.Errorf("rotated token is for user %d in family %s, want user 1 in family %s", rotated.Id, rotated.FamilyId, familyId)
+			}
+
+			id, err := db.ValidateRefreshToken("rotated")
+			if err != nil || id != 1 {
+				t.Errorf("ValidateRefreshToken(rotated) = %d, %v, want 1, nil", id, err)
+			}
+			if _, err := db.ValidateRefreshToken(token); err == nil {
+				t.Error("rotated away token still validates")
+			}
+		})
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	client := ClientInfo{}
+	db := newTestDB(t)
+
+	_, err := db.CreateRefreshToken("first", 1, client, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another session of the same user, in a family of its own
+	_, err = db.CreateRefreshToken("other", 1, client, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.RotateRefreshToken("first", "second", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.RotateRefreshToken("second", "third", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The leaked token is replayed
+	_, err = db.RotateRefreshToken("first", "attacker", client)
+	var reuseErr RefreshTokenReuseError
+	if !errors.As(err, &reuseErr) || reuseErr.UserId != 1 {
+		t.Fatalf("RotateRefreshToken() error = %v, want a reuse error for user 1", err)
+	}
+
+	tests := []struct {
+		token string
+		valid bool
+	}{
+		{"first", false},
+		{"second", false},
+		{"third", false},
+		{"attacker", false},
+		{"other", true},
+	}
+
+	for _, test := range tests {
+		_, err := db.ValidateRefreshToken(test.token)
+		if (err == nil) != test.valid {
+			t.Errorf("ValidateRefreshToken(%s) error = %v, want valid %v", test.token, err, test.valid)
+		}
+	}
+}
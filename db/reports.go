@@ -0,0 +1,281 @@
+package db
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+const (
+	ReportTargetChirp = "chirp"
+	ReportTargetUser  = "user"
+)
+
+const (
+	ReportActionDeleteChirp   = "delete_chirp"
+	ReportActionSuspendAuthor = "suspend_author"
+)
+
+const (
+	ReportStatusOpen      = "open"
+	ReportStatusActioned  = "actioned"
+	ReportStatusDismissed = "dismissed"
+)
+
+var ReportReasons = []string{
+	"spam",
+	"harassment",
+	"hate",
+	"violence",
+	"sexual_content",
+	"impersonation",
+	"other",
+}
+
+// Reason of reports filed by the system rather than a user
+const ReportReasonAutomated = "automated"
+
+type Report struct {
+	Id int `json:"id"`
+	// 0 for reports filed automatically, e.g. by the profanity filter
+	ReporterId int       `json:"reporter_id"`
+	TargetType string    `json:"target_type"`
+	TargetId   int       `json:"target_id"`
+	Reason     string    `json:"reason"`
+	Details    string    `json:"details"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	// Set once a moderator resolves the report
+	ResolvedBy int        `json:"resolved_by"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	Resolution string     `json:"resolution"`
+}
+
+type ReportFilter struct {
+	Status     *string
+	TargetType *string
+}
+
+func (filters ReportFilter) test(report Report) bool {
+	if filters.Status != nil && report.Status != *filters.Status {
+		return false
+	}
+
+	if filters.TargetType != nil && report.TargetType != *filters.TargetType {
+		return false
+	}
+
+	return true
+}
+
+func (db DBStructure) getNextReportId() int {
+	maxId := 0
+	for _, report := range db.Reports {
+		if report.Id > maxId {
+			maxId = report.Id
+		}
+	}
+
+	return maxId + 1
+}
+
+func (db DBStructure) reportTargetExists(targetType string, targetId int) bool {
+	switch targetType {
+	case ReportTargetChirp:
+		_, ok := db.Chirps[targetId]
+		return ok
+	case ReportTargetUser:
+		_, ok := db.Users[targetId]
+		return ok
+	}
+
+	return false
+}
+
+func (db *DB) CreateReport(report Report) (Report, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Report{}, err
+	}
+
+	if !dbStruct.reportTargetExists(report.TargetType, report.TargetId) {
+		return Report{}, NotFoundError{Model: "Report target"}
+	}
+
+	report.Id = dbStruct.getNextReportId()
+	report.Status = ReportStatusOpen
+	report.CreatedAt = time.Now().UTC()
+
+	dbStruct.Reports[report.Id] = report
+
+	err = db.writeDB(*dbStruct)
+	if err != nil {
+		return Report{}, err
+	}
+
+	return report, nil
+}
+
+func (db *DB) GetReports(filters ReportFilter) ([]Report, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return []Report{}, err
+	}
+
+	reports := []Report{}
+	for _, report := range dbStruct.Reports {
+		if filters.test(report) {
+			reports = append(reports, report)
+		}
+	}
+
+	slices.SortFunc(reports, func(a, b Report) int {
+		return cmp.Compare(a.Id, b.Id)
+	})
+
+	return reports, nil
+}
+
+func (db *DB) GetReportById(id int) (Report, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Report{}, err
+	}
+
+	report, ok := dbStruct.Reports[id]
+	if !ok {
+		return Report{}, NotFoundError{Model: "Report"}
+	}
+
+	return report, nil
+}
+
+// Moves an open report to its final status. Other open reports about the
+// same target are resolved along with it.
+func (db *DB) ResolveReport(id int, status string, moderatorId int, resolution string) (Report, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Report{}, err
+	}
+
+	report, ok := dbStruct.Reports[id]
+	if !ok {
+		return Report{}, NotFoundError{Model: "Report"}
+	}
+
+	if report.Status != ReportStatusOpen {
+		return Report{}, ReportTransitionError{From: report.Status, To: status}
+	}
+
+	dbStruct.resolveReports(report, status, moderatorId, resolution)
+
+	// A dismissed report clears the chirp for good
+	chirp, ok := dbStruct.Chirps[report.TargetId]
+	if ok && report.TargetType == ReportTargetChirp && status == ReportStatusDismissed {
+		chirp.Flagged = false
+		chirp.Held = false
+		dbStruct.Chirps[chirp.Id] = chirp
+	}
+
+	err = db.writeDB(*dbStruct)
+	if err != nil {
+		return Report{}, err
+	}
+
+	return dbStruct.Reports[id], nil
+}
+
+// Resolves the report along with every other open report of its target
+func (db DBStructure) resolveReports(report Report, status string, moderatorId int, resolution string) {
+	now := time.Now().UTC()
+	for _, r := range db.Reports {
+		if r.Status != ReportStatusOpen || r.TargetType != report.TargetType || r.TargetId != report.TargetId {
+			continue
+		}
+
+		r.Status = status
+		r.ResolvedBy = moderatorId
+		r.ResolvedAt = &now
+		r.Resolution = resolution
+		db.Reports[r.Id] = r
+	}
+}
+
+// Takes the action on an open report's target and resolves the report as
+// actioned. Both happen under the same lock, so concurrent moderators can't
+// both act on one report. Suspensions are only applied if canSuspend allows
+// it for the author. Returns the resolved report and the id of the deleted
+// chirp or suspended user.
+func (db *DB) ActionReport(id int, moderatorId int, action string, suspension *Restriction, canSuspend func(author User) bool) (Report, int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Report{}, 0, err
+	}
+
+	report, ok := dbStruct.Reports[id]
+	if !ok {
+		return Report{}, 0, NotFoundError{Model: "Report"}
+	}
+
+	if report.Status != ReportStatusOpen {
+		return Report{}, 0, ReportTransitionError{From: report.Status, To: ReportStatusActioned}
+	}
+
+	targetId := report.TargetId
+	switch action {
+	case ReportActionDeleteChirp:
+		if report.TargetType != ReportTargetChirp {
+			return Report{}, 0, InvalidReportActionError{Action: action, TargetType: report.TargetType}
+		}
+
+		delete(dbStruct.Chirps, report.TargetId)
+
+	case ReportActionSuspendAuthor:
+		if report.TargetType == ReportTargetChirp {
+			chirp, ok := dbStruct.Chirps[report.TargetId]
+			if !ok {
+				return Report{}, 0, NotFoundError{Model: "Chirp"}
+			}
+			targetId = chirp.AuthorId
+		}
+
+		author, ok := dbStruct.Users[targetId]
+		if !ok {
+			return Report{}, 0, NotFoundError{Model: "User"}
+		}
+
+		if !canSuspend(author) {
+			return Report{}, 0, ReportActionForbiddenError{}
+		}
+
+		author.Suspension = suspension
+		dbStruct.Users[author.Id] = author
+
+	default:
+		return Report{}, 0, InvalidReportActionError{Action: action, TargetType: report.TargetType}
+	}
+
+	dbStruct.resolveReports(report, ReportStatusActioned, moderatorId, action)
+
+	err = db.writeDB(*dbStruct)
+	if err != nil {
+		return Report{}, 0, err
+	}
+
+	return dbStruct.Reports[id], targetId, nil
+}
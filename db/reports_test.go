@@ -0,0 +1,85 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestActionReport(t *testing.T) {
+	db := newTestDB(t)
+
+	author, err := db.CreateUser("author@example.com", "unused", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chirp, err := db.CreateChirpChecked(Chirp{AuthorId: author.Id, Body: "spam"}, author.CreatedAt, func(recent []Chirp, chirp *Chirp) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := func(targetType string, targetId int) Report {
+		report, err := db.CreateReport(Report{ReporterId: 99, TargetType: targetType, TargetId: targetId, Reason: "spam"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+	allow := func(author User) bool { return true }
+
+	chirpReport := report(ReportTargetChirp, chirp.Id)
+	duplicate := report(ReportTargetChirp, chirp.Id)
+	userReport := report(ReportTargetUser, author.Id)
+
+	_, _, err = db.ActionReport(userReport.Id, 1, ReportActionDeleteChirp, nil, allow)
+	if !errors.As(err, &InvalidReportActionError{}) {
+		t.Fatalf("Expected invalid action for a user report, got %v", err)
+	}
+
+	_, _, err = db.ActionReport(userReport.Id, 1, ReportActionSuspendAuthor, &Restriction{}, func(author User) bool { return false })
+	if !errors.Is(err, ReportActionForbiddenError{}) {
+		t.Fatalf("Expected forbidden, got %v", err)
+	}
+	user, err := db.GetUserById(author.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Suspension != nil {
+		t.Fatal("Expected a forbidden action not to suspend")
+	}
+
+	resolved, targetId, err := db.ActionReport(chirpReport.Id, 1, ReportActionDeleteChirp, nil, allow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Status != ReportStatusActioned || resolved.Resolution != ReportActionDeleteChirp || targetId != chirp.Id {
+		t.Fatalf("Unexpected resolution: %+v, target %d", resolved, targetId)
+	}
+
+	// A second moderator loses the race on either report of the chirp
+	for _, id := range []int{chirpReport.Id, duplicate.Id} {
+		_, _, err = db.ActionReport(id, 2, ReportActionSuspendAuthor, &Restriction{Reason: "late"}, allow)
+		if !errors.As(err, &ReportTransitionError{}) {
+			t.Fatalf("Expected report %d to be closed, got %v", id, err)
+		}
+	}
+	user, err = db.GetUserById(author.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Suspension != nil {
+		t.Fatal("Expected a closed report not to suspend")
+	}
+
+	_, targetId, err = db.ActionReport(userReport.Id, 1, ReportActionSuspendAuthor, &Restriction{Reason: "spam"}, allow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err = db.GetUserById(author.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if targetId != author.Id || user.Suspension == nil || user.Suspension.Reason != "spam" {
+		t.Fatalf("Expected the author to be suspended, got %+v", user.Suspension)
+	}
+}
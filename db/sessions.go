@@ -0,0 +1,197 @@
+package db
+
+import (
+	"log"
+	"slices"
+	"time"
+)
+
+// A login on one device, made up of the refresh tokens rotated from it
+type Session struct {
+	Id         string    `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+}
+
+// Returns the current token of each of the user's sessions that can still
+// be refreshed, oldest session first
+func (db DBStructure) activeSessions(userId int) []RefreshToken {
+	sessions := []RefreshToken{}
+	for _, refreshToken := range db.RefreshTokens {
+		if refreshToken.Id == userId && refreshToken.validate() == nil {
+			sessions = append(sessions, refreshToken)
+		}
+	}
+
+	slices.SortFunc(sessions, func(a, b RefreshToken) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return sessions
+}
+
+// Returns the user's sessions that can still be refreshed, most recently
+// used first
+func (db *DB) GetSessions(userId int) ([]Session, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return []Session{}, err
+	}
+
+	sessions := []Session{}
+	for _, refreshToken := range dbStruct.activeSessions(userId) {
+		sessions = append(sessions, Session{
+			Id:         refreshToken.FamilyId,
+			CreatedAt:  refreshToken.CreatedAt,
+			LastUsedAt: refreshToken.LastUsedAt,
+			ExpiresAt:  refreshToken.ExpiresAt,
+			IP:         refreshToken.IP,
+			UserAgent:  refreshToken.UserAgent,
+		})
+	}
+
+	slices.SortFunc(sessions, func(a, b Session) int {
+		return b.LastUsedAt.Compare(a.LastUsedAt)
+	})
+
+	return sessions, nil
+}
+
+// Reports whether the user's session still has a refresh token that wasn't
+// revoked. Revoked tokens are eventually deleted, so a session without any
+// tokens left counts as revoked too.
+func (db *DB) IsSessionActive(userId int, sessionId string) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return false, err
+	}
+
+	for _, refreshToken := range dbStruct.RefreshTokens {
+		if refreshToken.Id == userId && refreshToken.FamilyId == sessionId && refreshToken.RevokedAt == nil {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (db *DB) RevokeSession(userId int, sessionId string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, refreshToken := range dbStruct.RefreshTokens {
+		if refreshToken.Id == userId && refreshToken.FamilyId == sessionId && refreshToken.RevokedAt == nil {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return NotFoundError{Model: "Session"}
+	}
+
+	dbStruct.revokeFamily(sessionId)
+
+	return db.writeDB(*dbStruct)
+}
+
+// Revokes every refresh token of the user and bumps their token version,
+// invalidating outstanding access tokens
+func (db *DB) RevokeAllSessions(userId int) (User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	user, ok := dbStruct.Users[userId]
+	if !ok {
+		return User{}, NotFoundError{Model: "User"}
+	}
+
+	now := time.Now().UTC()
+	for key, refreshToken := range dbStruct.RefreshTokens {
+		if refreshToken.Id == userId && refreshToken.RevokedAt == nil {
+			refreshToken.RevokedAt = &now
+			dbStruct.RefreshTokens[key] = refreshToken
+		}
+	}
+
+	user.TokenVersion++
+	dbStruct.Users[userId] = user
+
+	err = db.writeDB(*dbStruct)
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// Deletes refresh tokens that can no longer be used. Rotated tokens are kept
+// until they expire so reuse of a stolen token is still detected.
+func (db *DB) DeleteStaleRefreshTokens() (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	deleted := 0
+	for key, refreshToken := range dbStruct.RefreshTokens {
+		if refreshToken.RevokedAt != nil || refreshToken.ExpiresAt.Before(now) {
+			delete(dbStruct.RefreshTokens, key)
+			deleted++
+		}
+	}
+
+	if deleted == 0 {
+		return 0, nil
+	}
+
+	err = db.writeDB(*dbStruct)
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+// Calls DeleteStaleRefreshTokens every interval, forever. Meant to be run in
+// its own goroutine.
+func (db *DB) RunRefreshTokenJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deleted, err := db.DeleteStaleRefreshTokens()
+		if err != nil {
+			log.Printf("Refresh token janitor failed: %v\n", err)
+			continue
+		}
+
+		if deleted > 0 {
+			log.Printf("Refresh token janitor deleted %d tokens\n", deleted)
+		}
+	}
+}
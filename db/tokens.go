@@ -0,0 +1,119 @@
+package db
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+	// Id of the challenge token of a login waiting for a two-factor code
+	TokenPurposeTwoFactorChallenge = "two_factor_challenge"
+)
+
+// A token that can be used once, such as an email verification link or a
+// login challenge. Only its digest is stored.
+type OneTimeToken struct {
+	TokenHash string `json:"token_hash"`
+	Purpose   string `json:"purpose"`
+	UserId    int    `json:"user_id"`
+	// Address the token was sent to, so it stops working if the user
+	// changes their email in the meantime
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func hashOneTimeToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Stores a token, invalidating the user's earlier tokens for the same
+// purpose
+func (db *DB) CreateOneTimeToken(token string, purpose string, userId int, email string, lifetime time.Duration) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for key, oneTimeToken := range dbStruct.OneTimeTokens {
+		if oneTimeToken.ExpiresAt.Before(now) ||
+			(oneTimeToken.UserId == userId && oneTimeToken.Purpose == purpose) {
+			delete(dbStruct.OneTimeTokens, key)
+		}
+	}
+
+	tokenHash := hashOneTimeToken(token)
+	dbStruct.OneTimeTokens[tokenHash] = OneTimeToken{
+		TokenHash: tokenHash,
+		Purpose:   purpose,
+		UserId:    userId,
+		Email:     email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(lifetime),
+	}
+
+	return db.writeDB(*dbStruct)
+}
+
+func (db DBStructure) findOneTimeToken(token string, purpose string) (OneTimeToken, error) {
+	tokenHash := hashOneTimeToken(token)
+	oneTimeToken, ok := db.OneTimeTokens[tokenHash]
+	if !ok || subtle.ConstantTimeCompare([]byte(oneTimeToken.TokenHash), []byte(tokenHash)) != 1 ||
+		oneTimeToken.Purpose != purpose {
+		return OneTimeToken{}, NotFoundError{Model: "Token"}
+	}
+
+	if oneTimeToken.ExpiresAt.Before(time.Now().UTC()) {
+		return OneTimeToken{}, errors.New("Token expired")
+	}
+
+	return oneTimeToken, nil
+}
+
+// Returns the token without using it up
+func (db *DB) GetOneTimeToken(token string, purpose string) (OneTimeToken, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return OneTimeToken{}, err
+	}
+
+	return dbStruct.findOneTimeToken(token, purpose)
+}
+
+// Deletes the token and returns it, if it exists, hasn't expired and was
+// issued for the purpose
+func (db *DB) ConsumeOneTimeToken(token string, purpose string) (OneTimeToken, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return OneTimeToken{}, err
+	}
+
+	oneTimeToken, err := dbStruct.findOneTimeToken(token, purpose)
+	if err != nil {
+		return OneTimeToken{}, err
+	}
+
+	delete(dbStruct.OneTimeTokens, oneTimeToken.TokenHash)
+	err = db.writeDB(*dbStruct)
+	if err != nil {
+		return OneTimeToken{}, err
+	}
+
+	return oneTimeToken, nil
+}
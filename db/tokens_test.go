@@ -0,0 +1,127 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConsumeOneTimeToken(t *testing.T) {
+	tests := []struct {
+		name string
+		// Runs against a db holding the verification token "first" of user
+		// 1, returning the token and purpose to consume
+		setup   func(t *testing.T, db *DB) (string, string)
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			setup: func(t *testing.T, db *DB) (string, string) {
+				return "first", TokenPurposeVerifyEmail
+			},
+		},
+		{
+			name: "unknown",
+			setup: func(t *testing.T, db *DB) (string, string) {
+				return "unknown", TokenPurposeVerifyEmail
+			},
+			wantErr: true,
+		},
+		{
+			name: "other purpose",
+			setup: func(t *testing.T, db *DB) (string, string) {
+				return "first", TokenPurposeResetPassword
+			},
+			wantErr: true,
+		},
+		{
+			name: "already consumed",
+			setup: func(t *testing.T, db *DB) (string, string) {
+				_, err := db.ConsumeOneTimeToken("first", TokenPurposeVerifyEmail)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return "first", TokenPurposeVerifyEmail
+			},
+			wantErr: true,
+		},
+		{
+			name: "expired",
+			setup: func(t *testing.T, db *DB) (string, string) {
+				tamper(t, db, func(dbStruct *DBStructure) {
+					token := dbStruct.OneTimeTokens[hashOneTimeToken("first")]
+					token.ExpiresAt = time.Now().UTC().Add(-time.Minute)
+					dbStruct.OneTimeTokens[token.TokenHash] = token
+				})
+				return "first", TokenPurposeVerifyEmail
+			},
+			wantErr: true,
+		},
+		{
+			name: "replaced by a newer token",
+			setup: func(t *testing.T, db *DB) (string, string) {
+				err := db.CreateOneTimeToken("second", TokenPurposeVerifyEmail, 1, "user@example.com", time.Hour)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return "first", TokenPurposeVerifyEmail
+			},
+			wantErr: true,
+		},
+		{
+			name: "not replaced by a token for another purpose",
+			setup: func(t *testing.T, db *DB) (string, string) {
+				err := db.CreateOneTimeToken("reset", TokenPurposeResetPassword, 1, "user@example.com", time.Hour)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return "first", TokenPurposeVerifyEmail
+			},
+		},
+		{
+			name: "not replaced by another user's token",
+			setup: func(t *testing.T, db *DB) (string, string) {
+				err := db.CreateOneTimeToken("other", TokenPurposeVerifyEmail, 2, "other@example.com", time.Hour)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return "first", TokenPurposeVerifyEmail
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newTestDB(t)
+			err := db.CreateOneTimeToken("first", TokenPurposeVerifyEmail, 1, "user@example.com", time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, purpose := test.setup(t, db)
+
+			_, err = db.GetOneTimeToken(token, purpose)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("GetOneTimeToken() error = %v, want error %v", err, test.wantErr)
+			}
+
+			consumed, err := db.ConsumeOneTimeToken(token, purpose)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ConsumeOneTimeToken() error = %v, want error %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+
+			if consumed.UserId != 1 || consumed.Email != "user@example.com" || consumed.Purpose != purpose {
+				t.Errorf("consumed token = %+v, want user 1's %s token for user@example.com", consumed, purpose)
+			}
+
+			// Each token works once
+			_, err = db.ConsumeOneTimeToken(token, purpose)
+			if !errors.Is(err, NotFoundError{Model: "Token"}) {
+				t.Errorf("second ConsumeOneTimeToken() error = %v, want not found", err)
+			}
+		})
+	}
+}
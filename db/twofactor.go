@@ -0,0 +1,137 @@
+package db
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/PFrek/chirpy/totp"
+)
+
+type TwoFactor struct {
+	Secret string `json:"secret"`
+	// False while enrollment waits for the first valid code
+	Enabled   bool       `json:"enabled"`
+	EnabledAt *time.Time `json:"enabled_at"`
+	// Last TOTP step a code was accepted for, so codes can't be replayed
+	LastStep int64 `json:"last_step"`
+	// SHA-256 digests of the unused recovery codes
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func (user User) HasTwoFactor() bool {
+	return user.TwoFactor != nil && user.TwoFactor.Enabled
+}
+
+// Recovery codes are compared without dashes, spaces or case so they can be
+// typed however they were written down
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// Checks a TOTP code, recording its step. Recovery codes are only accepted
+// once enrollment is complete and are used up.
+func (twoFactor *TwoFactor) check(code string) bool {
+	step, ok := totp.Validate(twoFactor.Secret, code, time.Now().UTC(), twoFactor.LastStep)
+	if ok {
+		twoFactor.LastStep = step
+		return true
+	}
+
+	if !twoFactor.Enabled {
+		return false
+	}
+
+	codeHash := hashRecoveryCode(code)
+	for i, recoveryCode := range twoFactor.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(recoveryCode), []byte(codeHash)) == 1 {
+			twoFactor.RecoveryCodes = append(twoFactor.RecoveryCodes[:i], twoFactor.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Starts enrollment with a new secret, replacing any unfinished one
+func (db *DB) EnrollTwoFactor(userId int, secret string) (User, error) {
+	var err error
+	user, dbErr := db.updateUserWith(userId, func(user *User) {
+		if user.HasTwoFactor() {
+			err = TwoFactorEnabledError{}
+			return
+		}
+
+		user.TwoFactor = &TwoFactor{Secret: secret}
+	})
+	if dbErr != nil {
+		return User{}, dbErr
+	}
+
+	return user, err
+}
+
+// Completes enrollment once the user proves their authenticator works,
+// storing the digests of the given recovery codes
+func (db *DB) EnableTwoFactor(userId int, code string, recoveryCodes []string) (User, error) {
+	var err error
+	user, dbErr := db.updateUserWith(userId, func(user *User) {
+		if user.TwoFactor == nil {
+			err = NotFoundError{Model: "TwoFactor"}
+			return
+		}
+
+		if user.TwoFactor.Enabled {
+			err = TwoFactorEnabledError{}
+			return
+		}
+
+		if !user.TwoFactor.check(code) {
+			err = InvalidTwoFactorCodeError{}
+			return
+		}
+
+		now := time.Now().UTC()
+		user.TwoFactor.Enabled = true
+		user.TwoFactor.EnabledAt = &now
+		user.TwoFactor.RecoveryCodes = []string{}
+		for _, recoveryCode := range recoveryCodes {
+			user.TwoFactor.RecoveryCodes = append(user.TwoFactor.RecoveryCodes, hashRecoveryCode(recoveryCode))
+		}
+	})
+	if dbErr != nil {
+		return User{}, dbErr
+	}
+
+	return user, err
+}
+
+// Checks a TOTP or recovery code of a user with two-factor enabled
+func (db *DB) VerifyTwoFactorCode(userId int, code string) error {
+	var err error
+	_, dbErr := db.updateUserWith(userId, func(user *User) {
+		if !user.HasTwoFactor() {
+			err = NotFoundError{Model: "TwoFactor"}
+			return
+		}
+
+		if !user.TwoFactor.check(code) {
+			err = InvalidTwoFactorCodeError{}
+		}
+	})
+	if dbErr != nil {
+		return dbErr
+	}
+
+	return err
+}
+
+func (db *DB) DisableTwoFactor(userId int) (User, error) {
+	return db.updateUserWith(userId, func(user *User) {
+		user.TwoFactor = nil
+	})
+}
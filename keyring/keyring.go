@@ -0,0 +1,373 @@
+package keyring
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmRS256 = "RS256"
+)
+
+const (
+	// How long verifiers may cache the JWKS
+	JWKSMaxAge = 5 * time.Minute
+	// How long a rotated key is only published before it signs tokens, so
+	// verifiers holding a cached JWKS have picked it up by then
+	ActivationDelay = 2 * JWKSMaxAge
+)
+
+// PEM header holding the key's creation time, since file times don't
+// survive copies and restores
+const createdAtHeader = "Created-At"
+
+type Key struct {
+	Id        string
+	Algorithm string
+	Private   crypto.Signer
+	CreatedAt time.Time
+	// When the key starts signing tokens
+	ActivatesAt time.Time
+}
+
+func (key *Key) Public() crypto.PublicKey {
+	return key.Private.Public()
+}
+
+func (key *Key) SigningMethod() jwt.SigningMethod {
+	if key.Algorithm == AlgorithmRS256 {
+		return jwt.SigningMethodRS256
+	}
+
+	return jwt.SigningMethodEdDSA
+}
+
+// Holds the keys used to sign and verify tokens. Tokens are signed with the
+// newest active key, and every key in the directory remains valid for
+// verification so rotating doesn't invalidate tokens that are still in
+// circulation.
+type KeyRing struct {
+	dir       string
+	algorithm string
+	mu        *sync.RWMutex
+	keys      []*Key
+	// Delay between creating a key and signing with it
+	activationDelay time.Duration
+	now             func() time.Time
+}
+
+// Loads every PEM encoded private key in dir, generating a key with the
+// given algorithm if there are none
+func Load(dir string, algorithm string) (*KeyRing, error) {
+	if algorithm != AlgorithmEdDSA && algorithm != AlgorithmRS256 {
+		return nil, fmt.Errorf("Unsupported signing algorithm: %s", algorithm)
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create key directory: %v", err)
+	}
+
+	ring := &KeyRing{
+		dir:       dir,
+		algorithm: algorithm,
+		mu:        &sync.RWMutex{},
+		keys:      []*Key{},
+
+		activationDelay: ActivationDelay,
+		now:             time.Now,
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		key, err := loadKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load key %s: %v", path, err)
+		}
+
+		key.ActivatesAt = key.CreatedAt.Add(ring.activationDelay)
+		ring.keys = append(ring.keys, key)
+	}
+
+	slices.SortFunc(ring.keys, func(a, b *Key) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Id, b.Id)
+	})
+
+	if len(ring.keys) == 0 {
+		_, err := ring.Rotate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ring, nil
+}
+
+func loadKey(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("No PEM data found")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{
+		Id: strings.TrimSuffix(filepath.Base(path), ".pem"),
+	}
+
+	if createdAt, ok := block.Headers[createdAtHeader]; ok {
+		key.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s header: %v", createdAtHeader, err)
+		}
+	} else {
+		// Keys saved before the header was added only have their file time
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		key.CreatedAt = info.ModTime()
+	}
+
+	switch private := parsed.(type) {
+	case ed25519.PrivateKey:
+		key.Algorithm = AlgorithmEdDSA
+		key.Private = private
+	case *rsa.PrivateKey:
+		key.Algorithm = AlgorithmRS256
+		key.Private = private
+	default:
+		return nil, errors.New("Unsupported key type")
+	}
+
+	return key, nil
+}
+
+// Generates a new key that is published right away and becomes the signing
+// key once its activation delay has passed. Older keys are kept for
+// verification until they are removed from the directory.
+func (ring *KeyRing) Rotate() (*Key, error) {
+	var private crypto.Signer
+	var err error
+	if ring.algorithm == AlgorithmRS256 {
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	} else {
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to generate key: %v", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	now := ring.now().UTC()
+	key := &Key{
+		Id:          thumbprint(private.Public()),
+		Algorithm:   ring.algorithm,
+		Private:     private,
+		CreatedAt:   now,
+		ActivatesAt: now.Add(ring.activationDelay),
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{
+		Type:    "PRIVATE KEY",
+		Headers: map[string]string{createdAtHeader: now.Format(time.RFC3339Nano)},
+		Bytes:   der,
+	})
+	err = os.WriteFile(filepath.Join(ring.dir, key.Id+".pem"), data, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to save key: %v", err)
+	}
+
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	ring.keys = append(ring.keys, key)
+
+	return key, nil
+}
+
+// Removes a key so tokens signed with it are no longer accepted. The
+// signing key can't be retired, rotate first.
+func (ring *KeyRing) Retire(id string) error {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	index := slices.IndexFunc(ring.keys, func(key *Key) bool {
+		return key.Id == id
+	})
+	if index == -1 {
+		return fmt.Errorf("Unknown key id: %s", id)
+	}
+
+	if index == ring.signingIndex() {
+		return errors.New("Cannot retire the signing key")
+	}
+
+	err := os.Remove(filepath.Join(ring.dir, id+".pem"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Unable to remove key: %v", err)
+	}
+
+	ring.keys = slices.Delete(ring.keys, index, index+1)
+
+	return nil
+}
+
+// Derives the key id from the public key so ids never collide
+func thumbprint(public crypto.PublicKey) string {
+	der, _ := x509.MarshalPKIXPublicKey(public)
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Returns the index of the newest active key. While no key is active yet,
+// e.g. right after the first key was generated, the oldest key signs since
+// nobody can have cached a key set without it.
+func (ring *KeyRing) signingIndex() int {
+	now := ring.now()
+	for i := len(ring.keys) - 1; i >= 0; i-- {
+		if !ring.keys[i].ActivatesAt.After(now) {
+			return i
+		}
+	}
+
+	return 0
+}
+
+// Returns the key new tokens are signed with
+func (ring *KeyRing) SigningKey() *Key {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+
+	return ring.keys[ring.signingIndex()]
+}
+
+func (ring *KeyRing) Get(id string) (*Key, bool) {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+
+	for _, key := range ring.keys {
+		if key.Id == id {
+			return key, true
+		}
+	}
+
+	return nil, false
+}
+
+// Signs the claims with the current signing key, identifying it in the
+// token's kid header
+func (ring *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	key := ring.SigningKey()
+
+	token := jwt.NewWithClaims(key.SigningMethod(), claims)
+	token.Header["kid"] = key.Id
+
+	return token.SignedString(key.Private)
+}
+
+// Resolves the verification key for a token from its kid header, for use
+// with jwt.Parse
+func (ring *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	id, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, errors.New("Token has no key id")
+	}
+
+	key, ok := ring.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("Unknown key id: %s", id)
+	}
+
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("Algorithm %s doesn't match key %s", token.Method.Alg(), id)
+	}
+
+	return key.Public(), nil
+}
+
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	KeyId     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	// Ed25519 parameters
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	// RSA parameters
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Returns the public half of every key as a JSON Web Key Set (RFC 7517)
+func (ring *KeyRing) JWKS() JWKS {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+
+	encode := base64.RawURLEncoding.EncodeToString
+
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range ring.keys {
+		jwk := JWK{
+			Use:       "sig",
+			KeyId:     key.Id,
+			Algorithm: key.Algorithm,
+		}
+
+		switch public := key.Public().(type) {
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = encode(public)
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = encode(public.N.Bytes())
+			jwk.E = encode(big.NewInt(int64(public.E)).Bytes())
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
@@ -0,0 +1,160 @@
+package keyring
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func sign(t *testing.T, ring *KeyRing, subject string) string {
+	t.Helper()
+
+	token, err := ring.Sign(jwt.RegisteredClaims{Subject: subject})
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	return token
+}
+
+func verifies(ring *KeyRing, token string) bool {
+	_, err := jwt.Parse(token, ring.Keyfunc, jwt.WithValidMethods([]string{AlgorithmEdDSA, AlgorithmRS256}))
+	return err == nil
+}
+
+func TestRotation(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+	}{
+		{"ed25519", AlgorithmEdDSA},
+		{"rsa", AlgorithmRS256},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ring, err := Load(dir, test.algorithm)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+
+			clock := time.Now()
+			ring.now = func() time.Time { return clock }
+
+			first := ring.SigningKey()
+			if first.Algorithm != test.algorithm {
+				t.Errorf("generated a %s key, want %s", first.Algorithm, test.algorithm)
+			}
+			oldToken := sign(t, ring, "1")
+
+			second, err := ring.Rotate()
+			if err != nil {
+				t.Fatalf("Rotate() error = %v", err)
+			}
+			if second.Id == first.Id {
+				t.Fatalf("rotated to the same key %s", second.Id)
+			}
+
+			// The new key is published but doesn't sign until verifiers have
+			// had time to refresh their cached key sets
+			if len(ring.JWKS().Keys) != 2 {
+				t.Errorf("JWKS has %d keys, want 2", len(ring.JWKS().Keys))
+			}
+			if ring.SigningKey().Id != first.Id {
+				t.Fatalf("signing key is %s right after rotating, want %s", ring.SigningKey().Id, first.Id)
+			}
+			if second.ActivatesAt.Sub(second.CreatedAt) < JWKSMaxAge {
+				t.Errorf("key activates %v after creation, want at least the JWKS max age %v", second.ActivatesAt.Sub(second.CreatedAt), JWKSMaxAge)
+			}
+			err = ring.Retire(first.Id)
+			if err == nil {
+				t.Error("Retire() of the signing key should fail while the new key is pending")
+			}
+
+			clock = second.ActivatesAt
+			if ring.SigningKey().Id != second.Id {
+				t.Fatalf("signing key is %s after activation, want %s", ring.SigningKey().Id, second.Id)
+			}
+			newToken := sign(t, ring, "2")
+
+			// Tokens signed before the rotation stay valid
+			if !verifies(ring, oldToken) || !verifies(ring, newToken) {
+				t.Error("tokens signed with either key should verify after rotating")
+			}
+
+			// Reloading orders keys by the creation time saved with them,
+			// not by file times
+			past := time.Now().Add(-24 * time.Hour)
+			err = os.Chtimes(filepath.Join(dir, second.Id+".pem"), past, past)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reloaded, err := Load(dir, test.algorithm)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			reloaded.now = ring.now
+			if reloaded.SigningKey().Id != second.Id {
+				t.Errorf("reloaded signing key is %s, want %s", reloaded.SigningKey().Id, second.Id)
+			}
+			if !verifies(reloaded, oldToken) || !verifies(reloaded, newToken) {
+				t.Error("tokens signed with either key should verify after reloading")
+			}
+
+			err = ring.Retire(second.Id)
+			if err == nil {
+				t.Error("Retire() of the signing key should fail")
+			}
+
+			err = ring.Retire(first.Id)
+			if err != nil {
+				t.Fatalf("Retire() error = %v", err)
+			}
+			if verifies(ring, oldToken) {
+				t.Error("token signed with a retired key still verifies")
+			}
+			if !verifies(ring, newToken) {
+				t.Error("token signed with the signing key no longer verifies")
+			}
+
+			reloaded, err = Load(dir, test.algorithm)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if _, ok := reloaded.Get(first.Id); ok {
+				t.Error("retired key is loaded again")
+			}
+		})
+	}
+}
+
+func TestKeyfuncRejects(t *testing.T) {
+	ring, err := Load(t.TempDir(), AlgorithmEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid := ring.SigningKey().Id
+
+	tests := []struct {
+		name  string
+		token *jwt.Token
+	}{
+		{"no kid", jwt.New(jwt.SigningMethodEdDSA)},
+		{"unknown kid", &jwt.Token{Method: jwt.SigningMethodEdDSA, Header: map[string]interface{}{"kid": "unknown"}}},
+		{"algorithm mismatch", &jwt.Token{Method: jwt.SigningMethodRS256, Header: map[string]interface{}{"kid": kid}}},
+		{"symmetric algorithm", &jwt.Token{Method: jwt.SigningMethodHS256, Header: map[string]interface{}{"kid": kid}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ring.Keyfunc(test.token)
+			if err == nil {
+				t.Error("Keyfunc() error = nil, want an error")
+			}
+		})
+	}
+}
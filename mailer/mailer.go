@@ -0,0 +1,183 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Checks the address is a bare addr-spec with a domain, e.g. rejecting
+// "Name <a@b.c>" and "a@localhost"
+func ValidateAddress(address string) error {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil || parsed.Address != address || len(parsed.Name) > 0 {
+		return errors.New("Invalid email address")
+	}
+
+	_, domain, _ := strings.Cut(address, "@")
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return errors.New("Invalid email address")
+	}
+
+	return nil
+}
+
+func format(from string, msg Message) []byte {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "From: %s\r\n", from)
+	fmt.Fprintf(&builder, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&builder, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&builder, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return []byte(builder.String())
+}
+
+// Used when SMTPMailer.Timeout isn't set
+const DefaultSMTPTimeout = 30 * time.Second
+
+type SMTPMailer struct {
+	// host:port of the server
+	Addr     string
+	From     string
+	Username string
+	Password string
+	// Limit for the whole exchange with the server, since net/smtp has no
+	// timeouts of its own and a stalled server would hang the send forever
+	Timeout time.Duration
+}
+
+// Does what smtp.SendMail does, over a connection with a deadline
+func (mailer SMTPMailer) Send(msg Message) error {
+	timeout := mailer.Timeout
+	if timeout == 0 {
+		timeout = DefaultSMTPTimeout
+	}
+
+	host, _, err := net.SplitHostPort(mailer.Addr)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", mailer.Addr, timeout)
+	if err != nil {
+		return err
+	}
+
+	err = conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(mailer.Username) > 0 {
+		err = client.Auth(smtp.PlainAuth("", mailer.Username, mailer.Password, host))
+		if err != nil {
+			return err
+		}
+	}
+
+	// The envelope takes the bare address, without a display name
+	from := mailer.From
+	if parsed, err := mail.ParseAddress(mailer.From); err == nil {
+		from = parsed.Address
+	}
+
+	err = client.Mail(from)
+	if err != nil {
+		return err
+	}
+
+	err = client.Rcpt(msg.To)
+	if err != nil {
+		return err
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(format(mailer.From, msg))
+	if err != nil {
+		return err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// Writes every message to its own .eml file instead of sending it, for
+// development and tests
+type FileMailer struct {
+	Dir  string
+	From string
+	mu   sync.Mutex
+	sent int
+}
+
+func NewFileMailer(dir string, from string) (*FileMailer, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create mail directory: %v", err)
+	}
+
+	return &FileMailer{Dir: dir, From: from}, nil
+}
+
+func (mailer *FileMailer) Send(msg Message) error {
+	mailer.mu.Lock()
+	defer mailer.mu.Unlock()
+
+	mailer.sent++
+	name := fmt.Sprintf("%d-%d.eml", time.Now().UnixNano(), mailer.sent)
+	return os.WriteFile(filepath.Join(mailer.Dir, name), format(mailer.From, msg), 0600)
+}
+
+// Writes messages to the log instead of sending them
+type LogMailer struct{}
+
+func (mailer LogMailer) Send(msg Message) error {
+	log.Printf("Mail to %s: %s\n%s\n", msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,67 @@
+package mailer
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestValidateAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		valid   bool
+	}{
+		{"user@example.com", true},
+		{"first.last+tag@mail.example.co.uk", true},
+		{"", false},
+		{"user", false},
+		{"user@", false},
+		{"@example.com", false},
+		{"user@localhost", false},
+		{"user@.example.com", false},
+		{"user@example.com.", false},
+		{"Name <user@example.com>", false},
+		{"<user@example.com>", false},
+		{" user@example.com", false},
+		{"user@example.com\r\nBcc: victim@example.com", false},
+		{"a@b.c, d@e.f", false},
+	}
+
+	for _, test := range tests {
+		err := ValidateAddress(test.address)
+		if (err == nil) != test.valid {
+			t.Errorf("ValidateAddress(%q) error = %v, want valid %v", test.address, err, test.valid)
+		}
+	}
+}
+
+func TestSMTPMailerTimeout(t *testing.T) {
+	// A server that accepts connections but never greets the client
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		<-done
+		conn.Close()
+	}()
+
+	mailer := SMTPMailer{Addr: listener.Addr().String(), From: "from@example.com", Timeout: 100 * time.Millisecond}
+
+	start := time.Now()
+	err = mailer.Send(Message{To: "to@example.com", Subject: "Test", Body: "Test"})
+	if err == nil {
+		t.Fatal("Send() error = nil for a stalled server")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Send() took %s, want about the 100ms timeout", elapsed)
+	}
+}
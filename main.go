@@ -1,28 +1,139 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"github.com/PFrek/chirpy/api"
+	"github.com/PFrek/chirpy/blob"
 	"github.com/PFrek/chirpy/db"
+	"github.com/PFrek/chirpy/keyring"
+	"github.com/PFrek/chirpy/mailer"
+	"github.com/PFrek/chirpy/passhash"
 	"github.com/joho/godotenv"
+	"golang.org/x/crypto/bcrypt"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
+func getEnvInt(name string, fallback int) int {
+	val, ok := os.LookupEnv(name)
+	if !ok {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", name, err)
+	}
+
+	return n
+}
+
+// Like getEnvInt, but refuses values outside of [min, max]
+func getEnvIntRange(name string, fallback int, min int, max int) int {
+	n := getEnvInt(name, fallback)
+	if n < min || n > max {
+		log.Fatalf("Invalid value for %s: must be between %d and %d, got %d", name, min, max, n)
+	}
+
+	return n
+}
+
 func main() {
 	err := godotenv.Load()
 	if err != nil {
 		log.Fatal("Error loading .env file")
 	}
-	jwtSecret := os.Getenv("JWT_SECRET")
+	jwtAlgorithm := os.Getenv("JWT_ALGORITHM")
+	if len(jwtAlgorithm) == 0 {
+		jwtAlgorithm = keyring.AlgorithmEdDSA
+	}
 	polkaKey := os.Getenv("POLKA_KEY")
+	auditKey := os.Getenv("AUDIT_KEY")
+	jwtConfig := api.JWTConfig{
+		Audience: os.Getenv("JWT_AUDIENCE"),
+		Leeway:   time.Duration(getEnvInt("JWT_LEEWAY_SECONDS", 30)) * time.Second,
+	}
+	if len(jwtConfig.Audience) == 0 {
+		jwtConfig.Audience = "chirpy-api"
+	}
+	chirpLengthLimits := api.ChirpLengthLimits{
+		Default:   getEnvInt("CHIRP_LENGTH_LIMIT", api.DefaultChirpLengthLimit),
+		ChirpyRed: getEnvInt("CHIRP_LENGTH_LIMIT_RED", api.DefaultRedChirpLengthLimit),
+	}
+	spamConfig := api.DefaultSpamConfig()
+	spamConfig.MaxChirpsPerMinute = getEnvInt("SPAM_MAX_CHIRPS_PER_MINUTE", spamConfig.MaxChirpsPerMinute)
+	spamConfig.MaxChirpsPerHour = getEnvInt("SPAM_MAX_CHIRPS_PER_HOUR", spamConfig.MaxChirpsPerHour)
+	spamConfig.HoldScore = getEnvInt("SPAM_HOLD_SCORE", spamConfig.HoldScore)
+	spamConfig.RejectScore = getEnvInt("SPAM_REJECT_SCORE", spamConfig.RejectScore)
+	duplicateMinutes := getEnvIntRange("SPAM_DUPLICATE_WINDOW_MINUTES", int(spamConfig.DuplicateWindow.Minutes()), 1, math.MaxInt32)
+	spamConfig.DuplicateWindow = time.Duration(duplicateMinutes) * time.Minute
+	newAccountMinutes := getEnvIntRange("SPAM_NEW_ACCOUNT_AGE_MINUTES", int(spamConfig.NewAccountAge.Minutes()), 1, math.MaxInt32)
+	spamConfig.NewAccountAge = time.Duration(newAccountMinutes) * time.Minute
+	maxSessions := getEnvInt("MAX_SESSIONS_PER_USER", 10)
+	throttleConfig := api.DefaultLoginThrottleConfig()
+	throttleConfig.MaxAccountFailures = getEnvInt("LOGIN_MAX_ACCOUNT_FAILURES", throttleConfig.MaxAccountFailures)
+	throttleConfig.MaxIPFailures = getEnvInt("LOGIN_MAX_IP_FAILURES", throttleConfig.MaxIPFailures)
+	// Password reset requests allowed per hour
+	resetsPerAddress := getEnvIntRange("PASSWORD_RESET_MAX_PER_ADDRESS", 3, 1, math.MaxInt32)
+	resetsPerIP := getEnvIntRange("PASSWORD_RESET_MAX_PER_IP", 20, 1, math.MaxInt32)
+	passwordConfig := passhash.DefaultConfig()
+	if algorithm := os.Getenv("PASSWORD_HASH_ALGORITHM"); len(algorithm) > 0 {
+		if !passhash.IsValidAlgorithm(algorithm) {
+			log.Fatalf("Invalid value for PASSWORD_HASH_ALGORITHM: %s", algorithm)
+		}
+		passwordConfig.Algorithm = algorithm
+	}
+	// Costs bcrypt would silently replace would make every login rehash
+	passwordConfig.BcryptCost = getEnvIntRange("BCRYPT_COST", passwordConfig.BcryptCost, bcrypt.MinCost, bcrypt.MaxCost)
+	passwordConfig.Argon2Time = uint32(getEnvIntRange("ARGON2_TIME", int(passwordConfig.Argon2Time), 1, math.MaxUint32))
+	passwordConfig.Argon2Threads = uint8(getEnvIntRange("ARGON2_THREADS", int(passwordConfig.Argon2Threads), 1, math.MaxUint8))
+	// Argon2 needs at least 8 KiB per thread
+	passwordConfig.Argon2Memory = uint32(getEnvIntRange("ARGON2_MEMORY_KIB", int(passwordConfig.Argon2Memory), 8*int(passwordConfig.Argon2Threads), math.MaxUint32))
+	passwordPolicy := passhash.DefaultPolicy()
+	passwordPolicy.MinLength = getEnvInt("PASSWORD_MIN_LENGTH", passwordPolicy.MinLength)
+	passwordPolicy.MinEntropy = float64(getEnvInt("PASSWORD_MIN_ENTROPY_BITS", int(passwordPolicy.MinEntropy)))
+	breachedDir := os.Getenv("BREACHED_PASSWORDS_DIR")
+	verificationConfig := api.DefaultVerificationConfig()
+	if actions, ok := os.LookupEnv("UNVERIFIED_RESTRICTED_ACTIONS"); ok {
+		verificationConfig.RequiredFor, err = api.ParseVerifiedActions(actions)
+		if err != nil {
+			log.Fatalf("Invalid value for UNVERIFIED_RESTRICTED_ACTIONS: %v", err)
+		}
+	}
+	resendSeconds := getEnvIntRange("VERIFICATION_RESEND_COOLDOWN_SECONDS", int(verificationConfig.ResendCooldown.Seconds()), 1, math.MaxInt32)
+	verificationConfig.ResendCooldown = time.Duration(resendSeconds) * time.Second
+	smtpTimeoutSeconds := getEnvIntRange("SMTP_TIMEOUT_SECONDS", int(mailer.DefaultSMTPTimeout.Seconds()), 1, math.MaxInt32)
+	baseURL := os.Getenv("BASE_URL")
+	mailFrom := os.Getenv("MAIL_FROM")
+	if len(mailFrom) == 0 {
+		mailFrom = "Chirpy <no-reply@chirpy.local>"
+	}
+	smtpAddr := os.Getenv("SMTP_ADDR")
+	mailDir := os.Getenv("MAIL_DIR")
+	sweepMinutes := getEnvInt("REFRESH_TOKEN_SWEEP_MINUTES", 60)
+	if sweepMinutes <= 0 {
+		log.Fatalf("Invalid value for REFRESH_TOKEN_SWEEP_MINUTES: must be positive, got %d", sweepMinutes)
+	}
+	sweepInterval := time.Duration(sweepMinutes) * time.Minute
 
-	const filepathRoot = "."
+	// Only this directory is served, the working directory also holds the
+	// database and keys
+	const filepathRoot = "static"
 	const port = "8080"
 	const dbPath = "database.json"
+	const blobPath = "blobs"
+	const keysPath = "keys"
+	const auditKeyPath = "audit.key"
+	const auditHeadPath = "audit.head"
 
 	dbg := flag.Bool("debug", false, "Enable debug mode")
+	adminEmail := flag.String("admin", "", "Grant the admin role to the user with this email")
 	flag.Parse()
 
 	if dbg != nil && *dbg == true {
@@ -30,20 +141,115 @@ func main() {
 		if err != nil {
 			log.Printf("Debug error: %v\n", err)
 		}
+
+		// The head belongs to the removed log
+		err = os.Remove(auditHeadPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Debug error: %v\n", err)
+		}
 	}
 
 	var apiConfig api.ApiConfig
-	db, err := db.NewDB(dbPath)
+	database, err := db.NewDB(dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	apiConfig.DB = database
+
+	// The audit log is keyed with AUDIT_KEY, or a generated key kept next to
+	// the db
+	auditKeyData := []byte(auditKey)
+	if len(auditKeyData) == 0 {
+		auditKeyData, err = db.LoadAuditKey(auditKeyPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	err = database.SetAuditSigning(auditKeyData, auditHeadPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go database.RunRefreshTokenJanitor(sweepInterval)
+
+	if len(*adminEmail) > 0 {
+		user, err := database.GetUserByEmail(*adminEmail)
+		if err != nil {
+			log.Fatalf("Unable to grant admin role: %v", err)
+		}
+
+		_, err = database.SetUserRole(user.Id, db.RoleAdmin)
+		if err != nil {
+			log.Fatalf("Unable to grant admin role: %v", err)
+		}
+		log.Printf("Granted admin role to %s\n", *adminEmail)
+	}
+
+	blobs, err := blob.NewStore(blobPath)
 	if err != nil {
 		log.Fatal(err)
 	}
-	apiConfig.DB = db
-	apiConfig.JWTSecret = jwtSecret
+	apiConfig.Blobs = blobs
+
+	keys, err := keyring.Load(keysPath, jwtAlgorithm)
+	if err != nil {
+		log.Fatal(err)
+	}
+	apiConfig.Keys = keys
+	apiConfig.JWT = jwtConfig
+
 	apiConfig.PolkaKey = polkaKey
+	apiConfig.ChirpLengthLimits = chirpLengthLimits
+	apiConfig.Spam = spamConfig
+	apiConfig.MaxSessions = maxSessions
+	apiConfig.LoginThrottle = api.NewLoginThrottle(throttleConfig)
+	apiConfig.ResetRequestsByAddress = api.NewRateLimiter(resetsPerAddress, time.Hour)
+	apiConfig.ResetRequestsByIP = api.NewRateLimiter(resetsPerIP, time.Hour)
+	apiConfig.Passwords = passwordConfig
+	err = apiConfig.HashDummyPassword()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(breachedDir) > 0 {
+		breached, err := passhash.OpenBreachedList(breachedDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		passwordPolicy.Breached = breached
+		log.Printf("Checking passwords against breached ranges in %s\n", breachedDir)
+	}
+	apiConfig.PasswordPolicy = passwordPolicy
+
+	// Mail goes through SMTP when configured, otherwise it's written to
+	// files or the log for development
+	switch {
+	case len(smtpAddr) > 0:
+		apiConfig.Mailer = mailer.SMTPMailer{
+			Addr:     smtpAddr,
+			From:     mailFrom,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			Timeout:  time.Duration(smtpTimeoutSeconds) * time.Second,
+		}
+	case len(mailDir) > 0:
+		fileMailer, err := mailer.NewFileMailer(mailDir, mailFrom)
+		if err != nil {
+			log.Fatal(err)
+		}
+		apiConfig.Mailer = fileMailer
+	default:
+		apiConfig.Mailer = mailer.LogMailer{}
+	}
+	apiConfig.Verification = verificationConfig
+	apiConfig.VerificationResends = api.NewRateLimiter(1, verificationConfig.ResendCooldown)
+
+	if len(baseURL) == 0 {
+		baseURL = "http://localhost:" + port
+	}
+	apiConfig.BaseURL = strings.TrimSuffix(baseURL, "/")
 
 	mux := http.NewServeMux()
-	fileserverHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
-	mux.Handle("/app/*", apiConfig.MiddlewareMetricsInc(fileserverHandler))
+	mux.Handle("/app/", apiConfig.AppHandler(filepathRoot))
 
 	mux.HandleFunc("GET /api/healthz", func(writer http.ResponseWriter, req *http.Request) {
 		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
@@ -56,18 +262,77 @@ func main() {
 	mux.HandleFunc("GET /api/chirps/{id}", apiConfig.GetChirpHandler)
 	mux.HandleFunc("DELETE /api/chirps/{id}", apiConfig.DeleteChirpHandler)
 
+	mux.HandleFunc("POST /api/attachments", apiConfig.PostAttachmentsHandler)
+	mux.HandleFunc("GET /media/{hash}", apiConfig.GetMediaHandler)
+
+	mux.HandleFunc("POST /api/reports", apiConfig.PostReportsHandler)
+
+	mux.HandleFunc("GET /.well-known/jwks.json", apiConfig.JWKSHandler)
+
 	mux.HandleFunc("POST /api/login", apiConfig.PostLoginHandler)
+	mux.HandleFunc("POST /api/login/2fa", apiConfig.PostLoginTwoFactorHandler)
 	mux.HandleFunc("POST /api/refresh", apiConfig.PostRefreshHandler)
 	mux.HandleFunc("POST /api/revoke", apiConfig.PostRevokeHandler)
 
+	mux.HandleFunc("POST /api/password/forgot", apiConfig.PostForgotPasswordHandler)
+	mux.HandleFunc("POST /api/password/reset", apiConfig.PostResetPasswordHandler)
+
+	mux.HandleFunc("GET /api/sessions", apiConfig.GetSessionsHandler)
+	mux.HandleFunc("DELETE /api/sessions/{id}", apiConfig.DeleteSessionHandler)
+	mux.HandleFunc("POST /api/logout-all", apiConfig.PostLogoutAllHandler)
+
+	mux.HandleFunc("POST /api/2fa/enroll", apiConfig.PostTwoFactorEnrollHandler)
+	mux.HandleFunc("POST /api/2fa/verify", apiConfig.PostTwoFactorVerifyHandler)
+	mux.HandleFunc("DELETE /api/2fa", apiConfig.DeleteTwoFactorHandler)
+
 	mux.HandleFunc("POST /api/users", apiConfig.PostUsersHandler)
+	// Deprecated, see PutUsersHandler
 	mux.HandleFunc("PUT /api/users", apiConfig.PutUsersHandler)
+	mux.HandleFunc("PATCH /api/users/me", apiConfig.PatchUserHandler)
+	mux.HandleFunc("POST /api/users/me/password", apiConfig.PostChangePasswordHandler)
+	mux.HandleFunc("POST /api/users/verify-email", apiConfig.PostVerifyEmailHandler)
+	mux.HandleFunc("POST /api/users/verify-email/resend", apiConfig.PostResendVerificationHandler)
 	mux.HandleFunc("GET /api/users", apiConfig.GetUsersHandler)
 	mux.HandleFunc("GET /api/users/{id}", apiConfig.GetUserHandler)
+	mux.HandleFunc("GET /api/users/by-username/{name}", apiConfig.GetUserByUsernameHandler)
+	mux.HandleFunc("POST /api/users/{id}/follow", apiConfig.PostFollowHandler)
+	mux.HandleFunc("DELETE /api/users/{id}/follow", apiConfig.DeleteFollowHandler)
+
+	// Administrative routes
+	adminOnly := func(handler http.HandlerFunc) http.Handler {
+		return apiConfig.MiddlewareRequireRole(db.RoleAdmin, handler)
+	}
+	moderatorOnly := func(handler http.HandlerFunc) http.Handler {
+		return apiConfig.MiddlewareRequireRole(db.RoleModerator, handler)
+	}
+
+	mux.Handle("POST /api/reset", adminOnly(apiConfig.ResetHandler))
+
+	mux.Handle("GET /admin/metrics", adminOnly(apiConfig.MetricsHandler))
+
+	mux.Handle("POST /admin/keys/rotate", adminOnly(apiConfig.PostRotateKeysHandler))
+	mux.Handle("DELETE /admin/keys/{kid}", adminOnly(apiConfig.DeleteKeyHandler))
+
+	mux.Handle("GET /admin/audit", adminOnly(apiConfig.GetAuditHandler))
+	mux.Handle("GET /admin/audit/verify", adminOnly(apiConfig.GetAuditVerifyHandler))
+
+	mux.Handle("GET /admin/profanity", adminOnly(apiConfig.GetProfanityRulesHandler))
+	mux.Handle("POST /admin/profanity", adminOnly(apiConfig.PostProfanityRulesHandler))
+	mux.Handle("PUT /admin/profanity/{id}", adminOnly(apiConfig.PutProfanityRuleHandler))
+	mux.Handle("DELETE /admin/profanity/{id}", adminOnly(apiConfig.DeleteProfanityRuleHandler))
+	mux.Handle("GET /admin/chirps/flagged", moderatorOnly(apiConfig.GetFlaggedChirpsHandler))
 
-	mux.HandleFunc("/api/reset", apiConfig.ResetHandler)
+	mux.Handle("GET /admin/users/{id}", moderatorOnly(apiConfig.GetAdminUserHandler))
+	mux.Handle("PUT /admin/users/{id}/role", adminOnly(apiConfig.PutUserRoleHandler))
+	mux.Handle("POST /admin/users/{id}/suspension", moderatorOnly(apiConfig.PostSuspensionHandler))
+	mux.Handle("DELETE /admin/users/{id}/suspension", moderatorOnly(apiConfig.DeleteSuspensionHandler))
+	mux.Handle("POST /admin/users/{id}/shadowban", moderatorOnly(apiConfig.PostShadowbanHandler))
+	mux.Handle("DELETE /admin/users/{id}/shadowban", moderatorOnly(apiConfig.DeleteShadowbanHandler))
 
-	mux.HandleFunc("GET /admin/metrics", apiConfig.MetricsHandler)
+	mux.Handle("GET /admin/reports", moderatorOnly(apiConfig.GetReportsHandler))
+	mux.Handle("GET /admin/reports/{id}", moderatorOnly(apiConfig.GetReportHandler))
+	mux.Handle("POST /admin/reports/{id}/dismiss", moderatorOnly(apiConfig.PostReportDismissHandler))
+	mux.Handle("POST /admin/reports/{id}/action", moderatorOnly(apiConfig.PostReportActionHandler))
 
 	// Webhooks
 	mux.HandleFunc("POST /api/polka/webhooks", apiConfig.PolkaWebhookHandler)
@@ -0,0 +1,241 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/http"
+)
+
+const (
+	TypeJPEG = "image/jpeg"
+	TypePNG  = "image/png"
+	TypeGIF  = "image/gif"
+)
+
+// Sniffs the content type of the data, returning an error for anything that
+// isn't a supported image format
+func DetectType(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case TypeJPEG, TypePNG, TypeGIF:
+		return contentType, nil
+	}
+
+	return "", errors.New("Unsupported media type")
+}
+
+// Removes EXIF and other embedded metadata (location, camera details, text
+// comments, XMP) without re-encoding the image. The EXIF orientation of a
+// JPEG is kept.
+func StripMetadata(contentType string, data []byte) ([]byte, error) {
+	switch contentType {
+	case TypeJPEG:
+		return stripJPEG(data)
+	case TypePNG:
+		return stripPNG(data)
+	case TypeGIF:
+		return stripGIF(data)
+	}
+
+	return data, nil
+}
+
+func stripJPEG(data []byte) ([]byte, error) {
+	malformed := errors.New("Malformed JPEG")
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, malformed
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF || i+1 >= len(data) {
+			return nil, malformed
+		}
+		marker := data[i+1]
+
+		// Fill bytes
+		if marker == 0xFF {
+			i++
+			continue
+		}
+
+		// Markers without a payload
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out.Write(data[i : i+2])
+			i += 2
+			continue
+		}
+
+		// Start of scan or end of image: the rest is entropy-coded data
+		if marker == 0xDA || marker == 0xD9 {
+			out.Write(data[i:])
+			break
+		}
+
+		if i+4 > len(data) {
+			return nil, malformed
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, malformed
+		}
+
+		// APP1 holds EXIF/XMP, APP13 holds IPTC, COM holds free-form comments.
+		// Only the orientation survives from the EXIF data, since viewers need
+		// it to show the photo upright.
+		switch marker {
+		case 0xE1:
+			orientation := exifOrientation(data[i+4 : end])
+			if orientation != 1 {
+				out.Write(orientationSegment(orientation))
+			}
+		case 0xED, 0xFE:
+		default:
+			out.Write(data[i:end])
+		}
+
+		i = end
+	}
+
+	return out.Bytes(), nil
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	signature := []byte("\x89PNG\r\n\x1a\n")
+	malformed := errors.New("Malformed PNG")
+	if !bytes.HasPrefix(data, signature) {
+		return nil, malformed
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(signature)
+
+	i := len(signature)
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, malformed
+		}
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		chunkType := string(data[i+4 : i+8])
+		// length, type, data and CRC
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, malformed
+		}
+
+		switch chunkType {
+		case "eXIf", "tEXt", "iTXt", "zTXt", "tIME":
+		default:
+			out.Write(data[i:end])
+		}
+
+		i = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// Returns the offset just past the sub-blocks starting at i, which end with
+// an empty block
+func skipSubBlocks(data []byte, i int) (int, bool) {
+	for i < len(data) {
+		length := int(data[i])
+		i++
+		if length == 0 {
+			return i, true
+		}
+		i += length
+	}
+
+	return 0, false
+}
+
+func stripGIF(data []byte) ([]byte, error) {
+	malformed := errors.New("Malformed GIF")
+	// Header and logical screen descriptor
+	if len(data) < 13 || !(bytes.HasPrefix(data, []byte("GIF87a")) || bytes.HasPrefix(data, []byte("GIF89a"))) {
+		return nil, malformed
+	}
+
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+	if i > len(data) {
+		return nil, malformed
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:i])
+
+	for i < len(data) {
+		switch data[i] {
+		// Trailer
+		case 0x3B:
+			out.WriteByte(0x3B)
+			return out.Bytes(), nil
+
+		// Image descriptor, optional local color table and image data
+		case 0x2C:
+			if i+10 > len(data) {
+				return nil, malformed
+			}
+			start := i
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			// LZW minimum code size
+			i++
+			if i > len(data) {
+				return nil, malformed
+			}
+
+			end, ok := skipSubBlocks(data, i)
+			if !ok {
+				return nil, malformed
+			}
+			out.Write(data[start:end])
+			i = end
+
+		case 0x21:
+			if i+2 > len(data) {
+				return nil, malformed
+			}
+			label := data[i+1]
+			end, ok := skipSubBlocks(data, i+2)
+			if !ok {
+				return nil, malformed
+			}
+
+			// Comments and plain text are dropped, as are application
+			// extensions (e.g. XMP) other than the ones holding the animation
+			// loop count
+			keep := label != 0xFE && label != 0x01
+			if label == 0xFF {
+				identifier := data[i+2 : end]
+				keep = bytes.HasPrefix(identifier, []byte("\x0bNETSCAPE2.0")) || bytes.HasPrefix(identifier, []byte("\x0bANIMEXTS1.0"))
+			}
+			if keep {
+				out.Write(data[i:end])
+			}
+			i = end
+
+		default:
+			return nil, malformed
+		}
+	}
+
+	// Some encoders leave out the trailer
+	out.WriteByte(0x3B)
+	return out.Bytes(), nil
+}
@@ -0,0 +1,216 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	return img
+}
+
+func jpegSegment(marker byte, payload string) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// Inserts EXIF, IPTC and comment segments after the SOI marker
+func testJPEG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, testImage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	out := append([]byte{}, data[:2]...)
+	out = append(out, jpegSegment(0xE1, "Exif\x00\x00GPS-SECRET")...)
+	out = append(out, jpegSegment(0xED, "Photoshop 3.0\x00IPTC-SECRET")...)
+	out = append(out, jpegSegment(0xFE, "COMMENT-SECRET")...)
+	return append(out, data[2:]...)
+}
+
+func pngChunk(chunkType string, payload string) []byte {
+	chunk := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(chunk, uint32(len(payload)))
+	copy(chunk[4:], chunkType)
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// Inserts text and EXIF chunks after the IHDR chunk
+func testPNG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, testImage())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	// Signature and IHDR
+	split := 8 + 12 + 13
+	out := append([]byte{}, data[:split]...)
+	out = append(out, pngChunk("tEXt", "Comment\x00TEXT-SECRET")...)
+	out = append(out, pngChunk("eXIf", "MM\x00*GPS-SECRET")...)
+	return append(out, data[split:]...)
+}
+
+// Encodes a two frame animation and inserts a comment, a plain text and an
+// XMP application extension after the loop count extension
+func testGIF(t *testing.T) []byte {
+	palette := color.Palette{color.Black, color.White}
+	frames := []*image.Paletted{
+		image.NewPaletted(image.Rect(0, 0, 4, 4), palette),
+		image.NewPaletted(image.Rect(0, 0, 4, 4), palette),
+	}
+	frames[1].Pix[0] = 1
+
+	var buf bytes.Buffer
+	err := gif.EncodeAll(&buf, &gif.GIF{Image: frames, Delay: []int{10, 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	loop := bytes.Index(data, []byte("NETSCAPE2.0"))
+	if loop < 0 {
+		t.Fatal("encoded GIF has no loop count extension")
+	}
+	// Identifier, then the 3 byte loop count sub-block and terminator
+	split := loop + len("NETSCAPE2.0") + 5
+
+	out := append([]byte{}, data[:split]...)
+	out = append(out, 0x21, 0xFE, byte(len("COMMENT-SECRET")))
+	out = append(out, "COMMENT-SECRET"...)
+	out = append(out, 0)
+	// Plain text grid position, size, cell size and colors, then the text
+	out = append(out, 0x21, 0x01, 12)
+	out = append(out, make([]byte, 12)...)
+	out = append(out, byte(len("PLAINTEXT-SECRET")))
+	out = append(out, "PLAINTEXT-SECRET"...)
+	out = append(out, 0)
+	out = append(out, 0x21, 0xFF, 11)
+	out = append(out, "XMP DataXMP"...)
+	out = append(out, byte(len("XMP-SECRET")))
+	out = append(out, "XMP-SECRET"...)
+	out = append(out, 0)
+	return append(out, data[split:]...)
+}
+
+func TestStripMetadata(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+		secrets     []string
+		// Decodes the result, returning the number of frames
+		decode func([]byte) (int, error)
+	}{
+		{
+			name:        "jpeg",
+			contentType: TypeJPEG,
+			data:        testJPEG(t),
+			secrets:     []string{"GPS-SECRET", "IPTC-SECRET", "COMMENT-SECRET"},
+			decode: func(data []byte) (int, error) {
+				_, err := jpeg.Decode(bytes.NewReader(data))
+				return 1, err
+			},
+		},
+		{
+			name:        "png",
+			contentType: TypePNG,
+			data:        testPNG(t),
+			secrets:     []string{"TEXT-SECRET", "GPS-SECRET"},
+			decode: func(data []byte) (int, error) {
+				_, err := png.Decode(bytes.NewReader(data))
+				return 1, err
+			},
+		},
+		{
+			name:        "gif",
+			contentType: TypeGIF,
+			data:        testGIF(t),
+			secrets:     []string{"COMMENT-SECRET", "PLAINTEXT-SECRET", "XMP-SECRET"},
+			decode: func(data []byte) (int, error) {
+				decoded, err := gif.DecodeAll(bytes.NewReader(data))
+				if err != nil {
+					return 0, err
+				}
+				if decoded.LoopCount != 0 {
+					return 0, nil
+				}
+				return len(decoded.Image), nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, secret := range test.secrets {
+				if !bytes.Contains(test.data, []byte(secret)) {
+					t.Fatalf("fixture is missing %q", secret)
+				}
+			}
+
+			stripped, err := StripMetadata(test.contentType, test.data)
+			if err != nil {
+				t.Fatalf("StripMetadata() error = %v", err)
+			}
+
+			for _, secret := range test.secrets {
+				if bytes.Contains(stripped, []byte(secret)) {
+					t.Errorf("stripped image still contains %q", secret)
+				}
+			}
+
+			before, err := test.decode(test.data)
+			if err != nil {
+				t.Fatalf("decoding the fixture: %v", err)
+			}
+			after, err := test.decode(stripped)
+			if err != nil {
+				t.Fatalf("decoding the stripped image: %v", err)
+			}
+			if after != before {
+				t.Errorf("stripped image has %d frames, want %d", after, before)
+			}
+		})
+	}
+}
+
+func TestStripMetadataMalformed(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+	}{
+		{"jpeg without SOI", TypeJPEG, []byte("not a jpeg")},
+		{"truncated jpeg segment", TypeJPEG, []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x40, 0x00}},
+		{"png without signature", TypePNG, []byte("not a png")},
+		{"truncated png chunk", TypePNG, append([]byte("\x89PNG\r\n\x1a\n"), 0, 0, 0, 0x40, 'I', 'H', 'D', 'R')},
+		{"gif without header", TypeGIF, []byte("not a gif at all")},
+		{"truncated gif extension", TypeGIF, append([]byte("GIF89a\x04\x00\x04\x00\x00\x00\x00"), 0x21, 0xFE, 0x20, 'a')},
+		{"unknown gif block", TypeGIF, append([]byte("GIF89a\x04\x00\x04\x00\x00\x00\x00"), 0x42)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := StripMetadata(test.contentType, test.data)
+			if err == nil {
+				t.Error("StripMetadata() error = nil, want an error")
+			}
+		})
+	}
+}
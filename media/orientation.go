@@ -0,0 +1,139 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const orientationTag = 0x0112
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// Returns the EXIF orientation of a JPEG, from 1 (upright) to 8, or 1 when
+// the image has none
+func Orientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF {
+		marker := data[i+1]
+		// Start of scan or end of image, metadata only comes before these
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+
+		if marker == 0xE1 {
+			orientation := exifOrientation(data[i+4 : end])
+			if orientation != 1 {
+				return orientation
+			}
+		}
+
+		i = end
+	}
+
+	return 1
+}
+
+// Reads the orientation tag of IFD0 from an APP1 payload
+func exifOrientation(payload []byte) int {
+	if !bytes.HasPrefix(payload, exifHeader) {
+		return 1
+	}
+	tiff := payload[len(exifHeader):]
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			break
+		}
+
+		// A SHORT value is stored in the first bytes of the value field
+		if order.Uint16(tiff[entry:entry+2]) == orientationTag && order.Uint16(tiff[entry+2:entry+4]) == 3 {
+			orientation := int(order.Uint16(tiff[entry+8 : entry+10]))
+			if orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			break
+		}
+	}
+
+	return 1
+}
+
+// Builds an APP1 segment whose EXIF data holds nothing but the orientation
+func orientationSegment(orientation int) []byte {
+	tiff := []byte("MM\x00\x2A")
+	// IFD0 right after the header, with a single entry
+	tiff = binary.BigEndian.AppendUint32(tiff, 8)
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientationTag)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, uint16(orientation))
+	tiff = binary.BigEndian.AppendUint16(tiff, 0)
+	// No next IFD
+	tiff = binary.BigEndian.AppendUint32(tiff, 0)
+
+	payload := append(append([]byte{}, exifHeader...), tiff...)
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// Maps a pixel of an image stored as w by h pixels to its position once
+// the image is turned upright according to its EXIF orientation
+func orientPoint(x, y, w, h, orientation int) (int, int) {
+	switch orientation {
+	// Mirrored horizontally
+	case 2:
+		return w - 1 - x, y
+	// Rotated 180°
+	case 3:
+		return w - 1 - x, h - 1 - y
+	// Mirrored vertically
+	case 4:
+		return x, h - 1 - y
+	// Mirrored along the top-left to bottom-right diagonal
+	case 5:
+		return y, x
+	// Rotated 90° counterclockwise, so turned clockwise
+	case 6:
+		return h - 1 - y, x
+	// Mirrored along the top-right to bottom-left diagonal
+	case 7:
+		return h - 1 - y, w - 1 - x
+	// Rotated 90° clockwise, so turned counterclockwise
+	case 8:
+		return y, w - 1 - x
+	}
+
+	return x, y
+}
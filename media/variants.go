@@ -0,0 +1,190 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// Refuse to decode images larger than this to avoid decompression bombs.
+// A decoded image takes up to 4 bytes per pixel.
+const maxPixels = 25_000_000
+
+type VariantSpec struct {
+	Name string
+	// Longest side of the variant, in pixels
+	MaxSize int
+}
+
+var VariantSpecs = []VariantSpec{
+	{Name: "thumbnail", MaxSize: 150},
+	{Name: "medium", MaxSize: 800},
+}
+
+type Variant struct {
+	Name        string
+	ContentType string
+	Width       int
+	Height      int
+	// Encoded image, nil when the original already fits the variant's size
+	Data []byte
+}
+
+// Returns the width and height of the image as displayed, i.e. after
+// applying its EXIF orientation
+func Dimensions(data []byte) (int, int, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if Orientation(data) >= 5 {
+		return config.Height, config.Width, nil
+	}
+
+	return config.Width, config.Height, nil
+}
+
+// Generates a downscaled copy of the image for every entry of VariantSpecs.
+// JPEGs are turned upright first, and animated GIFs are always re-encoded
+// as their first frame, even when they already fit.
+func GenerateVariants(contentType string, data []byte) ([]Variant, error) {
+	width, height, err := Dimensions(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if width*height > maxPixels {
+		return nil, errors.New("Image dimensions are too large")
+	}
+
+	src, err := decode(contentType, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Variants are encoded without EXIF data, so the orientation has to be
+	// applied to the pixels
+	orientation := 1
+	if contentType == TypeJPEG {
+		orientation = Orientation(data)
+	}
+
+	variants := []Variant{}
+	for _, spec := range VariantSpecs {
+		w, h := fitWithin(width, height, spec.MaxSize)
+		if w == width && h == height && orientation == 1 && contentType != TypeGIF {
+			variants = append(variants, Variant{
+				Name:        spec.Name,
+				ContentType: contentType,
+				Width:       width,
+				Height:      height,
+			})
+			continue
+		}
+
+		resized := resize(src, orientation, w, h)
+
+		variant := Variant{
+			Name:   spec.Name,
+			Width:  w,
+			Height: h,
+		}
+
+		var buf bytes.Buffer
+		// JPEG can't hold transparency, so PNG and GIF sources stay lossless
+		if contentType == TypeJPEG {
+			variant.ContentType = TypeJPEG
+			err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: 85})
+		} else {
+			variant.ContentType = TypePNG
+			err = png.Encode(&buf, resized)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		variant.Data = buf.Bytes()
+		variants = append(variants, variant)
+	}
+
+	return variants, nil
+}
+
+func decode(contentType string, data []byte) (image.Image, error) {
+	reader := bytes.NewReader(data)
+	switch contentType {
+	case TypeJPEG:
+		return jpeg.Decode(reader)
+	case TypePNG:
+		return png.Decode(reader)
+	case TypeGIF:
+		return gif.Decode(reader)
+	}
+
+	return nil, errors.New("Unsupported media type")
+}
+
+// Scales the dimensions down, preserving the aspect ratio, so that neither
+// side exceeds maxSize
+func fitWithin(width, height, maxSize int) (int, int) {
+	if width <= maxSize && height <= maxSize {
+		return width, height
+	}
+
+	if width >= height {
+		return maxSize, max(1, height*maxSize/width)
+	}
+
+	return max(1, width*maxSize/height), maxSize
+}
+
+// Turns the image upright and downscales it with a box filter: every
+// destination pixel is the average of the source pixels it covers. The
+// source is read a row at a time, so no full size copy of it is made.
+func resize(src image.Image, orientation int, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	uprightW, uprightH := srcW, srcH
+	if orientation >= 5 {
+		uprightW, uprightH = srcH, srcW
+	}
+
+	// Channel sums of the source pixels covered by each destination pixel,
+	// and how many there are
+	sums := make([]uint32, width*height*4)
+	counts := make([]uint32, width*height)
+
+	row := image.NewRGBA(image.Rect(0, 0, srcW, 1))
+	for sy := 0; sy < srcH; sy++ {
+		draw.Draw(row, row.Bounds(), src, image.Pt(bounds.Min.X, bounds.Min.Y+sy), draw.Src)
+
+		for sx := 0; sx < srcW; sx++ {
+			x, y := orientPoint(sx, sy, srcW, srcH, orientation)
+			i := (y*height/uprightH)*width + x*width/uprightW
+
+			counts[i]++
+			for c := range 4 {
+				sums[i*4+c] += uint32(row.Pix[sx*4+c])
+			}
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i, n := range counts {
+		if n == 0 {
+			continue
+		}
+
+		for c := range 4 {
+			dst.Pix[i*4+c] = uint8(sums[i*4+c] / n)
+		}
+	}
+
+	return dst
+}
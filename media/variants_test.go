@@ -0,0 +1,236 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"runtime"
+	"testing"
+)
+
+func TestFitWithin(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		maxSize       int
+		wantW, wantH  int
+	}{
+		{"already fits", 100, 50, 150, 100, 50},
+		{"exact fit", 150, 150, 150, 150, 150},
+		{"landscape", 1600, 900, 800, 800, 450},
+		{"portrait", 900, 1600, 800, 450, 800},
+		{"square", 1000, 1000, 150, 150, 150},
+		{"thin strip keeps a pixel", 10000, 1, 150, 150, 1},
+		{"tall strip keeps a pixel", 1, 10000, 150, 1, 150},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, h := fitWithin(test.width, test.height, test.maxSize)
+			if w != test.wantW || h != test.wantH {
+				t.Errorf("fitWithin(%d, %d, %d) = %d, %d, want %d, %d",
+					test.width, test.height, test.maxSize, w, h, test.wantW, test.wantH)
+			}
+		})
+	}
+}
+
+// Encodes a 200x100 JPEG, tagged with the given orientation unless it is 0
+func orientedJPEG(t *testing.T, orientation int) []byte {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 200, 100)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	if orientation == 0 {
+		return data
+	}
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, orientationSegment(orientation)...)
+	return append(out, data[2:]...)
+}
+
+func TestOrientation(t *testing.T) {
+	tests := []struct {
+		name        string
+		orientation int
+		want        int
+		wantW       int
+		wantH       int
+	}{
+		{"untagged", 0, 1, 200, 100},
+		{"upright", 1, 1, 200, 100},
+		{"rotated 180", 3, 3, 200, 100},
+		{"rotated 90", 6, 6, 100, 200},
+		{"rotated 270", 8, 8, 100, 200},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := orientedJPEG(t, test.orientation)
+
+			stripped, err := StripMetadata(TypeJPEG, data)
+			if err != nil {
+				t.Fatalf("StripMetadata() error = %v", err)
+			}
+
+			got := Orientation(stripped)
+			if got != test.want {
+				t.Errorf("Orientation() = %d, want %d", got, test.want)
+			}
+
+			w, h, err := Dimensions(stripped)
+			if err != nil {
+				t.Fatalf("Dimensions() error = %v", err)
+			}
+			if w != test.wantW || h != test.wantH {
+				t.Errorf("Dimensions() = %d, %d, want %d, %d", w, h, test.wantW, test.wantH)
+			}
+
+			variants, err := GenerateVariants(TypeJPEG, stripped)
+			if err != nil {
+				t.Fatalf("GenerateVariants() error = %v", err)
+			}
+			for _, variant := range variants {
+				if variant.Data == nil {
+					if test.want != 1 {
+						t.Errorf("%s variant reuses the original of a rotated image", variant.Name)
+					}
+					continue
+				}
+
+				decoded, err := jpeg.Decode(bytes.NewReader(variant.Data))
+				if err != nil {
+					t.Fatalf("decoding %s variant: %v", variant.Name, err)
+				}
+				bounds := decoded.Bounds()
+				if bounds.Dx() != variant.Width || bounds.Dy() != variant.Height {
+					t.Errorf("%s variant is %dx%d, reported as %dx%d",
+						variant.Name, bounds.Dx(), bounds.Dy(), variant.Width, variant.Height)
+				}
+				if (bounds.Dx() > bounds.Dy()) != (test.wantW > test.wantH) {
+					t.Errorf("%s variant is %dx%d, not turned upright", variant.Name, bounds.Dx(), bounds.Dy())
+				}
+			}
+		})
+	}
+}
+
+func TestResizeOrients(t *testing.T) {
+	// 2x1 image with a red left pixel and a blue right one
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	red := color.RGBA{0xFF, 0, 0, 0xFF}
+	blue := color.RGBA{0, 0, 0xFF, 0xFF}
+	src.Set(0, 0, red)
+	src.Set(1, 0, blue)
+
+	tests := []struct {
+		orientation int
+		// Position of the red pixel in the upright image
+		redX, redY int
+		w, h       int
+	}{
+		{1, 0, 0, 2, 1},
+		{2, 1, 0, 2, 1},
+		{3, 1, 0, 2, 1},
+		{4, 0, 0, 2, 1},
+		{5, 0, 0, 1, 2},
+		{6, 0, 0, 1, 2},
+		{7, 0, 1, 1, 2},
+		{8, 0, 1, 1, 2},
+	}
+
+	for _, test := range tests {
+		dst := resize(src, test.orientation, test.w, test.h)
+		bounds := dst.Bounds()
+		if bounds.Dx() != test.w || bounds.Dy() != test.h {
+			t.Errorf("resize(%d) is %dx%d, want %dx%d", test.orientation, bounds.Dx(), bounds.Dy(), test.w, test.h)
+			continue
+		}
+
+		if color.RGBAModel.Convert(dst.At(test.redX, test.redY)) != red {
+			t.Errorf("resize(%d) has no red pixel at %d,%d", test.orientation, test.redX, test.redY)
+		}
+	}
+}
+
+func TestResizeAverages(t *testing.T) {
+	// Alternating black and white columns average out to grey
+	src := image.NewGray(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x += 2 {
+		src.SetGray(x, 0, color.Gray{0xFF})
+		src.SetGray(x, 1, color.Gray{0xFF})
+	}
+
+	dst := resize(src, 1, 2, 1)
+	for x := range 2 {
+		if got := dst.RGBAAt(x, 0); got != (color.RGBA{0x7F, 0x7F, 0x7F, 0xFF}) {
+			t.Errorf("pixel %d = %v, want grey", x, got)
+		}
+	}
+}
+
+func TestResizeDoesNotCopySource(t *testing.T) {
+	src := image.NewYCbCr(image.Rect(0, 0, 2000, 2000), image.YCbCrSubsampleRatio420)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	resize(src, 6, 150, 150)
+	runtime.ReadMemStats(&after)
+
+	// A full RGBA copy of the source alone would take 16MB
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("resize() allocated %d bytes, want less than 1MB", allocated)
+	}
+}
+
+func TestGenerateVariantsTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	err := gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, 5000, 5001), color.Palette{color.Black}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = GenerateVariants(TypeGIF, buf.Bytes())
+	if err == nil {
+		t.Error("GenerateVariants() error = nil for an image over the pixel limit")
+	}
+}
+
+func TestGenerateVariantsGIF(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	frames := []*image.Paletted{
+		image.NewPaletted(image.Rect(0, 0, 20, 10), palette),
+		image.NewPaletted(image.Rect(0, 0, 20, 10), palette),
+	}
+
+	var buf bytes.Buffer
+	err := gif.EncodeAll(&buf, &gif.GIF{Image: frames, Delay: []int{10, 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variants, err := GenerateVariants(TypeGIF, buf.Bytes())
+	if err != nil {
+		t.Fatalf("GenerateVariants() error = %v", err)
+	}
+
+	for _, variant := range variants {
+		if variant.Data == nil {
+			t.Errorf("%s variant reuses the animated original", variant.Name)
+			continue
+		}
+
+		if variant.ContentType != TypePNG {
+			t.Errorf("%s variant is %s, want %s", variant.Name, variant.ContentType, TypePNG)
+		}
+		if variant.Width != 20 || variant.Height != 10 {
+			t.Errorf("%s variant is %dx%d, want 20x10", variant.Name, variant.Width, variant.Height)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+const (
+	argon2SaltSize = 16
+	argon2KeySize  = 32
+)
+
+type Config struct {
+	// Algorithm used for new hashes. Hashes in the other format are still
+	// verified, then replaced on the next login.
+	Algorithm  string
+	BcryptCost int
+	// Memory in KiB
+	Argon2Memory  uint32
+	Argon2Time    uint32
+	Argon2Threads uint8
+}
+
+// Parameters recommended by OWASP for Argon2id
+func DefaultConfig() Config {
+	return Config{
+		Algorithm:     AlgorithmArgon2id,
+		BcryptCost:    12,
+		Argon2Memory:  19 * 1024,
+		Argon2Time:    2,
+		Argon2Threads: 1,
+	}
+}
+
+func IsValidAlgorithm(algorithm string) bool {
+	return algorithm == AlgorithmBcrypt || algorithm == AlgorithmArgon2id
+}
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+var b64 = base64.RawStdEncoding
+
+// Hashes are stored in the PHC string format, e.g.
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+func (params argon2Params) String() string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.memory, params.time, params.threads,
+		b64.EncodeToString(params.salt), b64.EncodeToString(params.key))
+}
+
+func parseArgon2(hash string) (argon2Params, error) {
+	params := argon2Params{}
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return params, errors.New("Invalid argon2id hash")
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, errors.New("Unsupported argon2id version")
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads)
+	// argon2 panics when these are zero
+	if err != nil || params.time == 0 || params.threads == 0 {
+		return params, errors.New("Invalid argon2id parameters")
+	}
+
+	params.salt, err = b64.DecodeString(parts[4])
+	if err != nil {
+		return params, errors.New("Invalid argon2id salt")
+	}
+
+	params.key, err = b64.DecodeString(parts[5])
+	if err != nil {
+		return params, errors.New("Invalid argon2id key")
+	}
+
+	return params, nil
+}
+
+func (config Config) Hash(password string) (string, error) {
+	if config.Algorithm == AlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), config.BcryptCost)
+		return string(hash), err
+	}
+
+	salt := make([]byte, argon2SaltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	params := argon2Params{
+		memory:  config.Argon2Memory,
+		time:    config.Argon2Time,
+		threads: config.Argon2Threads,
+		salt:    salt,
+	}
+	params.key = argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, argon2KeySize)
+
+	return params.String(), nil
+}
+
+// Checks the password against a hash in either format
+func Verify(password string, hash string) (bool, error) {
+	if strings.HasPrefix(hash, "$"+AlgorithmArgon2id+"$") {
+		params, err := parseArgon2(hash)
+		if err != nil {
+			return false, err
+		}
+
+		key := argon2.IDKey([]byte(password), params.salt, params.time, params.memory, params.threads, uint32(len(params.key)))
+		return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Reports whether the hash was made with another algorithm or parameters
+// than the config's
+func (config Config) NeedsRehash(hash string) bool {
+	if config.Algorithm == AlgorithmBcrypt {
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != config.BcryptCost
+	}
+
+	params, err := parseArgon2(hash)
+	if err != nil {
+		return true
+	}
+
+	return params.memory != config.Argon2Memory ||
+		params.time != config.Argon2Time ||
+		params.threads != config.Argon2Threads ||
+		len(params.key) != argon2KeySize
+}
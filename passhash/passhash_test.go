@@ -0,0 +1,132 @@
+package passhash
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Cheap parameters so the tests run quickly
+func testConfig(algorithm string) Config {
+	return Config{
+		Algorithm:     algorithm,
+		BcryptCost:    bcrypt.MinCost,
+		Argon2Memory:  64,
+		Argon2Time:    1,
+		Argon2Threads: 1,
+	}
+}
+
+func TestVerify(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmBcrypt, AlgorithmArgon2id} {
+		t.Run(algorithm, func(t *testing.T) {
+			hash, err := testConfig(algorithm).Hash("correct horse")
+			if err != nil {
+				t.Fatalf("Hash() error = %v", err)
+			}
+
+			tests := []struct {
+				name     string
+				password string
+				want     bool
+			}{
+				{"correct", "correct horse", true},
+				{"wrong", "battery staple", false},
+				{"different case", "Correct horse", false},
+				{"empty", "", false},
+			}
+
+			for _, test := range tests {
+				got, err := Verify(test.password, hash)
+				if err != nil {
+					t.Fatalf("Verify(%s) error = %v", test.name, err)
+				}
+				if got != test.want {
+					t.Errorf("Verify(%s) = %v, want %v", test.name, got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	hash, err := testConfig(AlgorithmArgon2id).Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"empty", ""},
+		{"garbage", "not a hash"},
+		{"missing key", hash[:strings.LastIndex(hash, "$")]},
+		{"wrong version", strings.Replace(hash, "v=19", "v=16", 1)},
+		{"zero time", strings.Replace(hash, "t=1", "t=0", 1)},
+		{"zero threads", strings.Replace(hash, "p=1", "p=0", 1)},
+		{"bad salt", strings.Replace(hash, "$"+strings.Split(hash, "$")[4]+"$", "$!!!$", 1)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ok, err := Verify("password", test.hash)
+			if err == nil || ok {
+				t.Errorf("Verify() = %v, %v, want an error", ok, err)
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	bcryptHash, err := testConfig(AlgorithmBcrypt).Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	argon2Hash, err := testConfig(AlgorithmArgon2id).Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config func() Config
+		hash   string
+		want   bool
+	}{
+		{"same bcrypt cost", func() Config { return testConfig(AlgorithmBcrypt) }, bcryptHash, false},
+		{"other bcrypt cost", func() Config {
+			config := testConfig(AlgorithmBcrypt)
+			config.BcryptCost++
+			return config
+		}, bcryptHash, true},
+		{"argon2id to bcrypt", func() Config { return testConfig(AlgorithmBcrypt) }, argon2Hash, true},
+		{"same argon2id parameters", func() Config { return testConfig(AlgorithmArgon2id) }, argon2Hash, false},
+		{"other argon2id memory", func() Config {
+			config := testConfig(AlgorithmArgon2id)
+			config.Argon2Memory *= 2
+			return config
+		}, argon2Hash, true},
+		{"other argon2id time", func() Config {
+			config := testConfig(AlgorithmArgon2id)
+			config.Argon2Time++
+			return config
+		}, argon2Hash, true},
+		{"other argon2id threads", func() Config {
+			config := testConfig(AlgorithmArgon2id)
+			config.Argon2Threads++
+			return config
+		}, argon2Hash, true},
+		{"bcrypt to argon2id", func() Config { return testConfig(AlgorithmArgon2id) }, bcryptHash, true},
+		{"malformed", func() Config { return testConfig(AlgorithmArgon2id) }, "not a hash", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.config().NeedsRehash(test.hash); got != test.want {
+				t.Errorf("NeedsRehash() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package passhash
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	ViolationTooShort   = "too_short"
+	ViolationTooWeak    = "too_weak"
+	ViolationMatchEmail = "matches_email"
+	ViolationBreached   = "breached"
+)
+
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type Policy struct {
+	// Counted in characters, not bytes
+	MinLength int
+	// See EstimateEntropy
+	MinEntropy float64
+	// nil to skip the breached password check
+	Breached *BreachedList
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		MinLength:  8,
+		MinEntropy: 36,
+	}
+}
+
+// Returns every rule the password breaks, or nothing if it is acceptable
+func (policy Policy) Check(password string, email string) ([]Violation, error) {
+	violations := []Violation{}
+
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		violations = append(violations, Violation{
+			Code:    ViolationTooShort,
+			Message: fmt.Sprintf("Password must be at least %d characters long", policy.MinLength),
+		})
+	}
+
+	if EstimateEntropy(password) < policy.MinEntropy {
+		violations = append(violations, Violation{
+			Code:    ViolationTooWeak,
+			Message: "Password is too easy to guess, use a longer password or a wider mix of characters",
+		})
+	}
+
+	// The local part alone is just as guessable as the full address
+	normalized := strings.ToLower(password)
+	email = strings.ToLower(strings.TrimSpace(email))
+	localPart, _, _ := strings.Cut(email, "@")
+	if len(email) > 0 && (normalized == email || normalized == localPart) {
+		violations = append(violations, Violation{
+			Code:    ViolationMatchEmail,
+			Message: "Password must not be your email address",
+		})
+	}
+
+	if policy.Breached != nil {
+		breached, err := policy.Breached.Contains(password)
+		if err != nil {
+			return nil, err
+		}
+
+		if breached {
+			violations = append(violations, Violation{
+				Code:    ViolationBreached,
+				Message: "Password has appeared in a data breach, choose another one",
+			})
+		}
+	}
+
+	return violations, nil
+}
+
+// Estimates the entropy in bits of a password as its length times the bits
+// per character of the character classes it uses. Characters repeating the
+// previous one add nothing, so "aaaaaaaa" isn't mistaken for a strong
+// password.
+func EstimateEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	length := 0
+	var prev rune
+	for i, r := range []rune(password) {
+		switch {
+		case r < utf8.RuneSelf && unicode.IsLower(r):
+			lower = true
+		case r < utf8.RuneSelf && unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case r < utf8.RuneSelf:
+			symbol = true
+		default:
+			other = true
+		}
+
+		if i == 0 || r != prev {
+			length++
+		}
+		prev = r
+	}
+
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if other {
+		pool += 100
+	}
+
+	if pool == 0 {
+		return 0
+	}
+
+	return float64(length) * math.Log2(float64(pool))
+}
+
+// Breached passwords as SHA-1 digests, looked up on demand in a directory
+// of range files named after the first five hex characters of the digests,
+// like the k-anonymity range API of Have I Been Pwned. Only the file of the
+// password's prefix is read, so the full list never has to fit in memory.
+type BreachedList struct {
+	dir string
+}
+
+// Opens a directory of <PREFIX>.txt files holding one SUFFIX:count line per
+// digest, as written by the Pwned Passwords downloader
+func OpenBreachedList(dir string) (*BreachedList, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open breached passwords: %v", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("Unable to open breached passwords: %s is not a directory", dir)
+	}
+
+	return &BreachedList{dir: dir}, nil
+}
+
+func (list *BreachedList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := digest[:5], digest[5:]
+
+	// A missing range has no breached passwords
+	file, err := os.Open(filepath.Join(list.dir, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Unable to read breached passwords: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineSuffix, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(lineSuffix, suffix) {
+			return true, nil
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return false, fmt.Errorf("Unable to read breached passwords: %v", err)
+	}
+
+	return false, nil
+}
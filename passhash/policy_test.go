@@ -0,0 +1,117 @@
+package passhash
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Writes the range files of the passwords to a directory, as the Pwned
+// Passwords downloader would
+func writeBreachedRanges(t *testing.T, passwords ...string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for _, password := range passwords {
+		sum := sha1.Sum([]byte(password))
+		digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+		path := filepath.Join(dir, digest[:5]+".txt")
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = file.WriteString(digest[5:] + ":42\r\n")
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func violationCodes(violations []Violation) []string {
+	codes := []string{}
+	for _, violation := range violations {
+		codes = append(codes, violation.Code)
+	}
+	return codes
+}
+
+func TestPolicyCheck(t *testing.T) {
+	breached, err := OpenBreachedList(writeBreachedRanges(t, "Tr0ub4dor&3", "P@ssw0rd-2024"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := DefaultPolicy()
+	policy.Breached = breached
+
+	tests := []struct {
+		name     string
+		password string
+		email    string
+		want     []string
+	}{
+		{"acceptable", "correct horse battery staple", "user@example.com", []string{}},
+		{"too short", "x9#Lq7!", "user@example.com", []string{ViolationTooShort}},
+		{"too weak", "password", "user@example.com", []string{ViolationTooWeak}},
+		{"repeated characters", "aaaaaaaaaaaaaaaaaaaa", "user@example.com", []string{ViolationTooWeak}},
+		{"short and weak", "abc", "user@example.com", []string{ViolationTooShort, ViolationTooWeak}},
+		{"matches the email", "Longer.Address@Example.com", "longer.address@example.com", []string{ViolationMatchEmail}},
+		{"matches the local part", "Longer.Address-x", "longer.address-x@example.com", []string{ViolationMatchEmail}},
+		{"breached", "Tr0ub4dor&3", "user@example.com", []string{ViolationBreached}},
+		{"another breached password in the range files", "P@ssw0rd-2024", "user@example.com", []string{ViolationBreached}},
+		{"counted in characters", "ééééééé", "user@example.com", []string{ViolationTooShort, ViolationTooWeak}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			violations, err := policy.Check(test.password, test.email)
+			if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+			if got := violationCodes(violations); !slices.Equal(got, test.want) {
+				t.Errorf("Check() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBreachedListContains(t *testing.T) {
+	dir := writeBreachedRanges(t, "hunter2")
+	list, err := OpenBreachedList(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"hunter2", true},
+		{"Hunter2", false},
+		// No range file for its prefix
+		{"never breached", false},
+	}
+
+	for _, test := range tests {
+		got, err := list.Contains(test.password)
+		if err != nil {
+			t.Fatalf("Contains(%s) error = %v", test.password, err)
+		}
+		if got != test.want {
+			t.Errorf("Contains(%s) = %v, want %v", test.password, got, test.want)
+		}
+	}
+
+	_, err = OpenBreachedList(filepath.Join(dir, "missing"))
+	if err == nil {
+		t.Error("OpenBreachedList() of a missing directory should fail")
+	}
+}
@@ -0,0 +1,146 @@
+package text
+
+import (
+	"regexp"
+	"unicode"
+)
+
+// Every URL counts as this many characters regardless of its actual length,
+// so links can be shared without eating the whole chirp
+const URLWeight = 23
+
+var urlPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"]+`)
+
+func FindURLs(body string) []string {
+	return urlPattern.FindAllString(body, -1)
+}
+
+// Returns the length of a chirp as users perceive it: grapheme clusters, with
+// every URL counted as URLWeight
+func ChirpLength(body string) int {
+	length := 0
+	last := 0
+	for _, loc := range urlPattern.FindAllStringIndex(body, -1) {
+		length += GraphemeCount(body[last:loc[0]])
+		length += URLWeight
+		last = loc[1]
+	}
+	length += GraphemeCount(body[last:])
+
+	return length
+}
+
+// Counts user-perceived characters. This implements the subset of the
+// Unicode grapheme cluster boundary rules (UAX #29) that matters for chirps:
+// combining marks, emoji modifiers and ZWJ sequences, flags and Hangul.
+func GraphemeCount(s string) int {
+	count := 0
+	var prev rune
+	regionalRun := 0
+
+	for i, r := range s {
+		if i == 0 || isBoundary(prev, r, regionalRun) {
+			count++
+		}
+
+		if isRegionalIndicator(r) {
+			regionalRun++
+		} else {
+			regionalRun = 0
+		}
+		prev = r
+	}
+
+	return count
+}
+
+func isBoundary(prev, r rune, regionalRun int) bool {
+	switch {
+	case prev == '\r' && r == '\n':
+		return false
+	case isControl(prev) || isControl(r):
+		return true
+	case isExtend(r) || r == zwj:
+		return false
+	// Emoji joined by a zero width joiner render as one glyph
+	case prev == zwj && isPictographic(r):
+		return false
+	// Flags are pairs of regional indicators
+	case isRegionalIndicator(prev) && isRegionalIndicator(r):
+		return regionalRun%2 == 0
+	}
+
+	return hangulBoundary(prev, r)
+}
+
+// Zero width joiner
+const zwj = '\u200d'
+
+func isControl(r rune) bool {
+	return unicode.IsControl(r)
+}
+
+func isExtend(r rune) bool {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc):
+		return true
+	// Emoji skin tone modifiers
+	case r >= 0x1F3FB && r <= 0x1F3FF:
+		return true
+	// Tag characters used by subdivision flags
+	case r >= 0xE0020 && r <= 0xE007F:
+		return true
+	}
+
+	return false
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+func isPictographic(r rune) bool {
+	return (r >= 0x1F000 && r <= 0x1FAFF) || (r >= 0x2600 && r <= 0x27BF) || r == 0x2764
+}
+
+const (
+	hangulNone = iota
+	hangulL
+	hangulV
+	hangulT
+	hangulLV
+	hangulLVT
+)
+
+func hangulType(r rune) int {
+	switch {
+	case (r >= 0x1100 && r <= 0x115F) || (r >= 0xA960 && r <= 0xA97C):
+		return hangulL
+	case (r >= 0x1160 && r <= 0x11A7) || (r >= 0xD7B0 && r <= 0xD7C6):
+		return hangulV
+	case (r >= 0x11A8 && r <= 0x11FF) || (r >= 0xD7CB && r <= 0xD7FB):
+		return hangulT
+	case r >= 0xAC00 && r <= 0xD7A3:
+		if (r-0xAC00)%28 == 0 {
+			return hangulLV
+		}
+		return hangulLVT
+	}
+
+	return hangulNone
+}
+
+// Decomposed Hangul jamo combine into a single syllable
+func hangulBoundary(prev, r rune) bool {
+	p, c := hangulType(prev), hangulType(r)
+	switch p {
+	case hangulL:
+		return c != hangulL && c != hangulV && c != hangulLV && c != hangulLVT
+	case hangulLV, hangulV:
+		return c != hangulV && c != hangulT
+	case hangulLVT, hangulT:
+		return c != hangulT
+	}
+
+	return true
+}
@@ -0,0 +1,68 @@
+package text
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGraphemeCount(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  int
+	}{
+		{"empty", "", 0},
+		{"ascii", "hello", 5},
+		{"crlf is one character", "a\r\nb", 3},
+		{"precomposed accent", "caf\u00e9", 4},
+		{"combining accent", "cafe\u0301", 4},
+		{"stacked combining marks", "a\u0323\u0301b", 2},
+		{"emoji", "👍", 1},
+		{"emoji with skin tone", "👍🏽", 1},
+		{"zwj family", "👨‍👩‍👧‍👦", 1},
+		{"emoji with variation selector", "❤️", 1},
+		{"flag", "🇫🇷", 1},
+		{"two flags", "🇫🇷🇩🇪", 2},
+		{"odd regional indicators", "🇫🇷🇩", 2},
+		{"hangul jamo syllable", "\u1100\u1161\u11a8", 1},
+		{"precomposed hangul", "한국어", 3},
+		{"cjk", "日本語", 3},
+		{"mixed", "hi 👋🏻!", 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := GraphemeCount(test.input)
+			if got != test.want {
+				t.Errorf("GraphemeCount(%q) = %d, want %d", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+func TestChirpLength(t *testing.T) {
+	longURL := "https://example.com/" + strings.Repeat("a", 200)
+
+	tests := []struct {
+		name  string
+		input string
+		want  int
+	}{
+		{"plain", "hello", 5},
+		{"url only", "https://example.com", URLWeight},
+		{"long url", longURL, URLWeight},
+		{"text around url", "see http://example.com/x now", 4 + URLWeight + 4},
+		{"two urls", "https://a.example https://b.example", 2*URLWeight + 1},
+		{"emoji and url", "👍🏽 https://example.com", 2 + URLWeight},
+		{"not a url", "example.com", 11},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ChirpLength(test.input)
+			if got != test.want {
+				t.Errorf("ChirpLength(%q) = %d, want %d", test.input, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package text
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	ActionMask   = "mask"
+	ActionReject = "reject"
+	ActionFlag   = "flag"
+)
+
+func IsValidAction(action string) bool {
+	switch action {
+	case ActionMask, ActionReject, ActionFlag:
+		return true
+	}
+
+	return false
+}
+
+// Reports whether a rule for the word can match anything. Words are folded
+// to latin letters, so e.g. punctuation or other scripts fold to nothing.
+func CanMatch(word string) bool {
+	return len(normalize(word, false)) > 0
+}
+
+type Rule struct {
+	Word   string
+	Action string
+}
+
+type FilterResult struct {
+	Text string
+	// Set when a rule with ActionReject matched
+	Rejected bool
+	// Set when a rule with ActionFlag matched
+	Flagged bool
+	// Words of the rules that matched
+	Matches []string
+}
+
+const mask = "****"
+
+// Symbols and digits commonly substituted for letters
+var leet = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'8': 'b',
+	'9': 'g',
+	'@': 'a',
+	'$': 's',
+	'!': 'i',
+	'|': 'l',
+	'+': 't',
+}
+
+// Letters from other scripts that look identical to latin ones
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h',
+	'о': 'o', 'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'і': 'i',
+	'ј': 'j', 'ѕ': 's', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v',
+	'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'ζ': 'z',
+	// Latin look-alikes
+	'ı': 'i', 'ł': 'l', 'ø': 'o', 'ß': 's',
+}
+
+var tokenPattern = regexp.MustCompile(`\S+`)
+
+// Folds a word into the form rules are matched against: lowercase latin
+// letters only, with accents, confusables and repeated letters collapsed
+func normalize(word string, mapLeet bool) string {
+	var builder strings.Builder
+	var last rune
+	for _, r := range strings.ToLower(word) {
+		r = foldRune(r)
+		if mapLeet {
+			if mapped, ok := leet[r]; ok {
+				r = mapped
+			}
+		}
+
+		// Drops separators used to dodge filters, e.g. "f.o.r.n.a.x"
+		if r < 'a' || r > 'z' {
+			continue
+		}
+		if r == last {
+			continue
+		}
+
+		builder.WriteRune(r)
+		last = r
+	}
+
+	return builder.String()
+}
+
+func foldRune(r rune) rune {
+	// Fullwidth forms
+	if r >= 'ａ' && r <= 'ｚ' {
+		return r - 'ａ' + 'a'
+	}
+	if r >= '０' && r <= '９' {
+		return r - '０' + '0'
+	}
+
+	if mapped, ok := confusables[r]; ok {
+		return mapped
+	}
+
+	if mapped, ok := accents[r]; ok {
+		return mapped
+	}
+
+	return r
+}
+
+// Precomposed accented latin letters and their base letter
+var accents = map[rune]rune{
+	'à': 'a', 'á': 'a', 'â': 'a', 'ã': 'a', 'ä': 'a', 'å': 'a', 'ç': 'c', 'è': 'e',
+	'é': 'e', 'ê': 'e', 'ë': 'e', 'ì': 'i', 'í': 'i', 'î': 'i', 'ï': 'i', 'ñ': 'n',
+	'ò': 'o', 'ó': 'o', 'ô': 'o', 'õ': 'o', 'ö': 'o', 'ù': 'u', 'ú': 'u', 'û': 'u',
+	'ü': 'u', 'ý': 'y', 'ÿ': 'y', 'ā': 'a', 'ă': 'a', 'ą': 'a', 'ć': 'c', 'ĉ': 'c',
+	'ċ': 'c', 'č': 'c', 'ď': 'd', 'ē': 'e', 'ĕ': 'e', 'ė': 'e', 'ę': 'e', 'ě': 'e',
+	'ĝ': 'g', 'ğ': 'g', 'ġ': 'g', 'ģ': 'g', 'ĥ': 'h', 'ĩ': 'i', 'ī': 'i', 'ĭ': 'i',
+	'į': 'i', 'ĵ': 'j', 'ķ': 'k', 'ĺ': 'l', 'ļ': 'l', 'ľ': 'l', 'ń': 'n', 'ņ': 'n',
+	'ň': 'n', 'ō': 'o', 'ŏ': 'o', 'ő': 'o', 'ŕ': 'r', 'ŗ': 'r', 'ř': 'r', 'ś': 's',
+	'ŝ': 's', 'ş': 's', 'š': 's', 'ţ': 't', 'ť': 't', 'ũ': 'u', 'ū': 'u', 'ŭ': 'u',
+	'ů': 'u', 'ű': 'u', 'ų': 'u', 'ŵ': 'w', 'ŷ': 'y', 'ź': 'z', 'ż': 'z', 'ž': 'z',
+	'ơ': 'o', 'ư': 'u', 'ǎ': 'a', 'ǐ': 'i', 'ǒ': 'o', 'ǔ': 'u', 'ǖ': 'u', 'ǘ': 'u',
+	'ǚ': 'u', 'ǜ': 'u', 'ǟ': 'a', 'ǡ': 'a', 'ǧ': 'g', 'ǩ': 'k', 'ǫ': 'o', 'ǭ': 'o',
+	'ǰ': 'j', 'ǵ': 'g', 'ǹ': 'n', 'ǻ': 'a', 'ȁ': 'a', 'ȃ': 'a', 'ȅ': 'e', 'ȇ': 'e',
+	'ȉ': 'i', 'ȋ': 'i', 'ȍ': 'o', 'ȏ': 'o', 'ȑ': 'r', 'ȓ': 'r', 'ȕ': 'u', 'ȗ': 'u',
+	'ș': 's', 'ț': 't', 'ȟ': 'h', 'ȧ': 'a', 'ȩ': 'e', 'ȫ': 'o', 'ȭ': 'o', 'ȯ': 'o',
+	'ȱ': 'o', 'ȳ': 'y',
+}
+
+func isEdgePunct(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsMark(r)
+}
+
+// Checks every whitespace separated token of the body against the rules.
+// Tokens matching a masking rule are replaced, keeping surrounding
+// punctuation.
+func Filter(body string, rules []Rule) FilterResult {
+	normalized := map[string]Rule{}
+	for _, rule := range rules {
+		word := normalize(rule.Word, false)
+		if len(word) == 0 {
+			continue
+		}
+
+		// Keep the most severe action when several rules fold to the same word
+		existing, ok := normalized[word]
+		if !ok || severity(rule.Action) > severity(existing.Action) {
+			normalized[word] = rule
+		}
+	}
+
+	result := FilterResult{Matches: []string{}}
+
+	var builder strings.Builder
+	last := 0
+	for _, loc := range tokenPattern.FindAllStringIndex(body, -1) {
+		builder.WriteString(body[last:loc[0]])
+		last = loc[1]
+
+		token := body[loc[0]:loc[1]]
+		replacement, rule, ok := matchToken(token, normalized)
+		if !ok {
+			builder.WriteString(token)
+			continue
+		}
+
+		result.Matches = append(result.Matches, rule.Word)
+		switch rule.Action {
+		case ActionReject:
+			result.Rejected = true
+			builder.WriteString(token)
+		case ActionFlag:
+			result.Flagged = true
+			builder.WriteString(token)
+		default:
+			builder.WriteString(replacement)
+		}
+	}
+	builder.WriteString(body[last:])
+
+	result.Text = builder.String()
+	return result
+}
+
+// Returns the masked token and the rule it matched
+func matchToken(token string, rules map[string]Rule) (string, Rule, bool) {
+	// Trim punctuation that isn't part of the word, e.g. "fornax!", but
+	// only if it doesn't stand in for a letter
+	core := strings.TrimFunc(token, isEdgePunct)
+	if len(core) > 0 {
+		if rule, ok := rules[normalize(core, true)]; ok {
+			start := strings.Index(token, core)
+			return token[:start] + mask + token[start+len(core):], rule, true
+		}
+	}
+
+	// The punctuation might be leetspeak, e.g. "$harbert" or "kerfuffl3"
+	if rule, ok := rules[normalize(token, true)]; ok {
+		return mask, rule, true
+	}
+
+	// Or separators between letters, e.g. "f.o.r.n.a.x"
+	if rule, ok := rules[normalize(token, false)]; ok {
+		return mask, rule, true
+	}
+
+	return "", Rule{}, false
+}
+
+func severity(action string) int {
+	switch action {
+	case ActionReject:
+		return 2
+	case ActionFlag:
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,159 @@
+package text
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	rules := []Rule{
+		{Word: "kerfuffle", Action: ActionMask},
+		{Word: "sharbert", Action: ActionMask},
+		{Word: "fornax", Action: ActionMask},
+		{Word: "blorp", Action: ActionReject},
+		{Word: "zonk", Action: ActionFlag},
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		want     string
+		rejected bool
+		flagged  bool
+		matches  []string
+	}{
+		{
+			name:    "clean",
+			body:    "This is a kerfuffled opinion",
+			want:    "This is a kerfuffled opinion",
+			matches: []string{},
+		},
+		{
+			name:    "exact match",
+			body:    "This is a kerfuffle opinion",
+			want:    "This is a **** opinion",
+			matches: []string{"kerfuffle"},
+		},
+		{
+			name:    "case insensitive",
+			body:    "SHARBERT and Fornax",
+			want:    "**** and ****",
+			matches: []string{"sharbert", "fornax"},
+		},
+		{
+			name:    "keeps surrounding punctuation",
+			body:    "What a (kerfuffle)!",
+			want:    "What a (****)!",
+			matches: []string{"kerfuffle"},
+		},
+		{
+			name:    "leetspeak",
+			body:    "$h4rb3rt kerfuffl3",
+			want:    "**** ****",
+			matches: []string{"sharbert", "kerfuffle"},
+		},
+		{
+			name:    "separators",
+			body:    "f.o.r.n.a.x",
+			want:    "****",
+			matches: []string{"fornax"},
+		},
+		{
+			name:    "repeated letters",
+			body:    "fooornaaax",
+			want:    "****",
+			matches: []string{"fornax"},
+		},
+		{
+			name:    "accents",
+			body:    "fórnäx",
+			want:    "****",
+			matches: []string{"fornax"},
+		},
+		{
+			name:    "cyrillic confusables",
+			body:    "fоrnах",
+			want:    "****",
+			matches: []string{"fornax"},
+		},
+		{
+			name:    "fullwidth",
+			body:    "ｆｏｒｎａｘ",
+			want:    "****",
+			matches: []string{"fornax"},
+		},
+		{
+			name:     "reject keeps the text",
+			body:     "blorp you",
+			want:     "blorp you",
+			rejected: true,
+			matches:  []string{"blorp"},
+		},
+		{
+			name:    "flag keeps the text",
+			body:    "total zonk",
+			want:    "total zonk",
+			flagged: true,
+			matches: []string{"zonk"},
+		},
+		{
+			name:    "keeps whitespace",
+			body:    "  fornax\n\tok ",
+			want:    "  ****\n\tok ",
+			matches: []string{"fornax"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := Filter(test.body, rules)
+			if result.Text != test.want {
+				t.Errorf("Text = %q, want %q", result.Text, test.want)
+			}
+			if result.Rejected != test.rejected {
+				t.Errorf("Rejected = %v, want %v", result.Rejected, test.rejected)
+			}
+			if result.Flagged != test.flagged {
+				t.Errorf("Flagged = %v, want %v", result.Flagged, test.flagged)
+			}
+			if !slices.Equal(result.Matches, test.matches) {
+				t.Errorf("Matches = %v, want %v", result.Matches, test.matches)
+			}
+		})
+	}
+}
+
+func TestFilterMostSevereRule(t *testing.T) {
+	rules := []Rule{
+		{Word: "fornax", Action: ActionMask},
+		{Word: "FORNAX", Action: ActionReject},
+		{Word: "f-o-r-n-a-x", Action: ActionFlag},
+	}
+
+	result := Filter("fornax", rules)
+	if !result.Rejected || result.Flagged {
+		t.Errorf("Filter() = %+v, want only the reject rule to apply", result)
+	}
+}
+
+func TestCanMatch(t *testing.T) {
+	tests := []struct {
+		word string
+		want bool
+	}{
+		{"fornax", true},
+		{"FÖRNAX", true},
+		{"ƒ", false},
+		{"форнакс", true},
+		{"...", false},
+		{"日本", false},
+		{"1234", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if got := CanMatch(test.word); got != test.want {
+			t.Errorf("CanMatch(%q) = %v, want %v", test.word, got, test.want)
+		}
+	}
+}
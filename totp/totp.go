@@ -0,0 +1,111 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Parameters every authenticator app supports, as specified by RFC 6238
+const (
+	Digits = 6
+	Period = 30 * time.Second
+	// Steps before and after the current one still accepted, to allow for
+	// clock drift and slow typists
+	Skew = 1
+)
+
+const secretSize = 20
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Returns a random base32 encoded secret
+func GenerateSecret() (string, error) {
+	data := make([]byte, secretSize)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(data), nil
+}
+
+// Returns the otpauth:// URI authenticator apps read from a QR code
+func ProvisioningURI(secret string, issuer string, account string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+func step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Computes the HOTP value (RFC 4226) of the secret for the given counter
+func code(secret string, counter int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("Invalid TOTP secret: %v", err)
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for range Digits {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", Digits, value%mod), nil
+}
+
+// Returns the code for the secret at time t
+func Code(secret string, t time.Time) (string, error) {
+	return code(secret, step(t))
+}
+
+// Checks the code against the steps around time t. Returns the matching
+// step, which must be greater than lastStep so a code can't be replayed.
+func Validate(secret string, input string, t time.Time, lastStep int64) (int64, bool) {
+	input = strings.ReplaceAll(input, " ", "")
+	if len(input) != Digits {
+		return 0, false
+	}
+
+	current := step(t)
+	for s := current - Skew; s <= current+Skew; s++ {
+		if s <= lastStep {
+			continue
+		}
+
+		expected, err := code(secret, s)
+		if err != nil {
+			return 0, false
+		}
+
+		if hmac.Equal([]byte(expected), []byte(input)) {
+			return s, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,82 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// The SHA-1 secret of the RFC 6238 test vectors, "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B, truncated to six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, test := range tests {
+		got, err := Code(rfcSecret, time.Unix(test.unix, 0))
+		if err != nil {
+			t.Fatalf("Code() error = %v", err)
+		}
+		if got != test.want {
+			t.Errorf("Code(%d) = %s, want %s", test.unix, got, test.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	current := step(now)
+
+	codeAt := func(offset time.Duration) string {
+		code, err := Code(rfcSecret, now.Add(offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		lastStep int64
+		wantStep int64
+		wantOk   bool
+	}{
+		{"current step", codeAt(0), 0, current, true},
+		{"with spaces", codeAt(0)[:3] + " " + codeAt(0)[3:], 0, current, true},
+		{"previous step", codeAt(-Period), 0, current - 1, true},
+		{"next step", codeAt(Period), 0, current + 1, true},
+		{"outside the skew", codeAt(-2 * Period), 0, 0, false},
+		{"replayed", codeAt(0), current, 0, false},
+		{"older than the last step", codeAt(-Period), current - 1, 0, false},
+		{"later than the last step", codeAt(Period), current, current + 1, true},
+		{"wrong code", "000000", 0, 0, false},
+		{"too short", codeAt(0)[:5], 0, 0, false},
+		{"empty", "", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := Validate(rfcSecret, test.input, now, test.lastStep)
+			if ok != test.wantOk || got != test.wantStep {
+				t.Errorf("Validate(%q) = %d, %v, want %d, %v", test.input, got, ok, test.wantStep, test.wantOk)
+			}
+		})
+	}
+}
+
+func TestValidateInvalidSecret(t *testing.T) {
+	_, ok := Validate("not base32!", "123456", time.Now(), 0)
+	if ok {
+		t.Error("Validate() accepted a code for an invalid secret")
+	}
+}